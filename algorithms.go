@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"math/big"
+	"slices"
+	"sync"
+
+	"github.com/dadrus/httpsig"
+	"github.com/go-ap/errors"
+	draft "github.com/go-fed/httpsig"
+)
+
+// KeyMatcher reports if a public key can be used with a signature algorithm.
+type KeyMatcher func(crypto.PublicKey) bool
+
+// Algorithm associates the names of a signature algorithm with the public keys it can verify signatures for.
+type Algorithm struct {
+	// RFC is the RFC9421 name of the algorithm, it is empty if the algorithm can't be used for RFC9421 signatures.
+	RFC httpsig.SignatureAlgorithm
+	// Draft is the draft-cavage name of the algorithm, it is empty if the algorithm can't be used for
+	// draft-cavage signatures.
+	Draft draft.Algorithm
+	// Key matches the public keys that the algorithm can be used with.
+	Key KeyMatcher
+	// Explicit algorithms are used for RFC9421 signatures only when the signature specifies them, they are
+	// never inferred from the public key.
+	Explicit bool
+}
+
+// RSAKeys matches RSA public keys with the modulus of one of the sizes, in bytes.
+// When no sizes are passed, it matches all RSA public keys.
+func RSAKeys(sizes ...int) KeyMatcher {
+	return func(pub crypto.PublicKey) bool {
+		pk, ok := pub.(*rsa.PublicKey)
+		return ok && (len(sizes) == 0 || slices.Contains(sizes, pk.Size()))
+	}
+}
+
+// ECDSAKeys matches ECDSA public keys on the curve.
+func ECDSAKeys(curve elliptic.Curve) KeyMatcher {
+	return func(pub crypto.PublicKey) bool {
+		pk, ok := pub.(*ecdsa.PublicKey)
+		return ok && pk.Curve == curve
+	}
+}
+
+// SecretKeys matches the secrets shared with trusted peers, used for HMAC signatures.
+func SecretKeys() KeyMatcher {
+	return func(key crypto.PublicKey) bool {
+		_, ok := key.([]byte)
+		return ok
+	}
+}
+
+// Ed25519Keys matches Ed25519 public keys.
+func Ed25519Keys() KeyMatcher {
+	return func(pub crypto.PublicKey) bool {
+		_, ok := pub.(ed25519.PublicKey)
+		return ok
+	}
+}
+
+func defaultAlgorithms() []Algorithm {
+	return []Algorithm{
+		{RFC: httpsig.RsaPkcs1v15Sha256, Draft: draft.RSA_SHA256, Key: RSAKeys(128, 256)},
+		{RFC: httpsig.RsaPkcs1v15Sha384, Draft: draft.RSA_SHA384, Key: RSAKeys(384)},
+		{RFC: httpsig.RsaPkcs1v15Sha512, Draft: draft.RSA_SHA512, Key: RSAKeys(512)},
+		// NOTE(marius): the hash used with RSA-PSS is not tied to the key size, so these can be used only
+		// when the signature specifies the algorithm explicitly, or when its padding matches, see KeyPolicy.RSAPSSFallback.
+		{RFC: httpsig.RsaPssSha512, Key: RSAKeys(), Explicit: true},
+		{RFC: httpsig.RsaPssSha384, Key: RSAKeys(), Explicit: true},
+		{RFC: httpsig.RsaPssSha256, Key: RSAKeys(), Explicit: true},
+		{RFC: httpsig.EcdsaP256Sha256, Draft: draft.ECDSA_SHA256, Key: ECDSAKeys(elliptic.P256())},
+		{RFC: httpsig.EcdsaP384Sha384, Draft: draft.ECDSA_SHA384, Key: ECDSAKeys(elliptic.P384())},
+		{RFC: httpsig.EcdsaP521Sha512, Draft: draft.ECDSA_SHA512, Key: ECDSAKeys(elliptic.P521())},
+		{RFC: httpsig.Ed25519, Draft: draft.ED25519, Key: Ed25519Keys()},
+		{RFC: httpsig.HmacSha256, Key: SecretKeys()},
+		{RFC: httpsig.HmacSha384, Key: SecretKeys(), Explicit: true},
+		{RFC: httpsig.HmacSha512, Key: SecretKeys(), Explicit: true},
+	}
+}
+
+var algorithms = struct {
+	sync.RWMutex
+	list []Algorithm
+}{list: defaultAlgorithms()}
+
+// RegisterAlgorithm adds an algorithm to the registry used for mapping public keys to signature algorithms.
+// When multiple RFC9421 algorithms match a key, the one registered first is used, while for draft-cavage
+// signatures all the matching algorithms are tried in order of registration.
+// Registering an algorithm with the RFC9421 or draft-cavage name of an existing one replaces it, the new
+// algorithm taking its place in the order of registration.
+func RegisterAlgorithm(alg Algorithm) {
+	if alg.Key == nil {
+		return
+	}
+	algorithms.Lock()
+	defer algorithms.Unlock()
+
+	pos := -1
+	list := make([]Algorithm, 0, len(algorithms.list)+1)
+	for _, existing := range algorithms.list {
+		replaced := false
+		if alg.RFC != "" && existing.RFC == alg.RFC {
+			existing.RFC = ""
+			replaced = true
+		}
+		if alg.Draft != "" && existing.Draft == alg.Draft {
+			existing.Draft = ""
+			replaced = true
+		}
+		if replaced && pos < 0 {
+			pos = len(list)
+			list = append(list, alg)
+		}
+		// NOTE(marius): an algorithm that shares only one of its names with the new one keeps the other.
+		if existing.RFC != "" || existing.Draft != "" {
+			list = append(list, existing)
+		}
+	}
+	if pos < 0 {
+		list = append(list, alg)
+	}
+	algorithms.list = list
+}
+
+// matchingAlgorithms returns the registered algorithms that can be used with the public key.
+func matchingAlgorithms(pub crypto.PublicKey) []Algorithm {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+
+	var matches []Algorithm
+	for _, alg := range algorithms.list {
+		if alg.Key(pub) {
+			matches = append(matches, alg)
+		}
+	}
+	return matches
+}
+
+// supportsAlgorithm reports if the RFC9421 algorithm can be used with the public key.
+func supportsAlgorithm(pub crypto.PublicKey, alg httpsig.SignatureAlgorithm) bool {
+	return slices.ContainsFunc(matchingAlgorithms(pub), func(a Algorithm) bool { return a.RFC == alg })
+}
+
+// pssAlgorithms returns the RSA-PSS algorithms that can be used with the public key.
+func pssAlgorithms(pub crypto.PublicKey) []httpsig.SignatureAlgorithm {
+	var algs []httpsig.SignatureAlgorithm
+	for _, alg := range matchingAlgorithms(pub) {
+		switch alg.RFC {
+		case httpsig.RsaPssSha256, httpsig.RsaPssSha384, httpsig.RsaPssSha512:
+			algs = append(algs, alg.RFC)
+		}
+	}
+	return algs
+}
+
+// pssHash returns the hash function used by the RSA-PSS algorithm.
+func pssHash(alg httpsig.SignatureAlgorithm) crypto.Hash {
+	switch alg {
+	case httpsig.RsaPssSha256:
+		return crypto.SHA256
+	case httpsig.RsaPssSha384:
+		return crypto.SHA384
+	case httpsig.RsaPssSha512:
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// rsaPSSAlgorithm returns which one of the RSA-PSS algorithms created the signature, by recovering its
+// encoded message with the public key and checking the padding for a salt as long as the hash, or an empty value if the signature
+// isn't an RSA-PSS one, eg: for PKCS #1 v1.5 signatures.
+// NOTE(marius): this costs a single public key operation, and it doesn't verify the signature, which is
+// still done afterwards using the returned algorithm.
+func rsaPSSAlgorithm(pub *rsa.PublicKey, sig []byte, algs []httpsig.SignatureAlgorithm) httpsig.SignatureAlgorithm {
+	k := (pub.N.BitLen() + 7) / 8
+	if len(sig) != k || len(algs) == 0 {
+		return ""
+	}
+	m := new(big.Int).SetBytes(sig)
+	if m.Cmp(pub.N) >= 0 {
+		return ""
+	}
+	em := m.Exp(m, big.NewInt(int64(pub.E)), pub.N).FillBytes(make([]byte, k))
+
+	emBits := pub.N.BitLen() - 1
+	emLen := (emBits + 7) / 8
+	if k > emLen {
+		if em[0] != 0 {
+			return ""
+		}
+		em = em[1:]
+	}
+	if em[emLen-1] != 0xbc {
+		return ""
+	}
+	for _, alg := range algs {
+		h := pssHash(alg)
+		hLen := h.Size()
+		if !h.Available() || emLen < 2*hLen+2 {
+			continue
+		}
+		db := slices.Clone(em[:emLen-hLen-1])
+		mgf1XOR(db, h, em[emLen-hLen-1:emLen-1])
+		db[0] &= 0xff >> (8*emLen - emBits)
+
+		// NOTE(marius): httpsig uses salts as long as the hash, so the unmasked data block must be
+		// all the zero padding, followed by 0x01 and the salt. Unmasking it with the wrong hash can't
+		// produce that many zeros, unlike what a salt of any length would allow.
+		ps := len(db) - hLen - 1
+		if db[ps] == 0x01 && !slices.ContainsFunc(db[:ps], func(b byte) bool { return b != 0 }) {
+			return alg
+		}
+	}
+	return ""
+}
+
+// mgf1XOR XORs out with the MGF1 mask generated from seed, see RFC8017 Appendix B.2.1.
+func mgf1XOR(out []byte, h crypto.Hash, seed []byte) {
+	var counter [4]byte
+	for done := 0; done < len(out); {
+		d := h.New()
+		d.Write(seed)
+		d.Write(counter[:])
+		for _, b := range d.Sum(nil) {
+			if done >= len(out) {
+				break
+			}
+			out[done] ^= b
+			done++
+		}
+		binary.BigEndian.PutUint32(counter[:], binary.BigEndian.Uint32(counter[:])+1)
+	}
+}
+
+func errUnsupportedKey(pub crypto.PublicKey) error {
+	switch pk := pub.(type) {
+	case *rsa.PublicKey:
+		return errors.Newf("unsupported RSA key size %d bits", pk.N.BitLen())
+	case *ecdsa.PublicKey:
+		return errors.Newf("unsupported ECDSA key curve")
+	}
+	return errors.Newf("unsupported public key type %T", pub)
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/dadrus/httpsig"
+	draft "github.com/go-fed/httpsig"
+	"github.com/google/go-cmp/cmp"
+)
+
+var prvKeyECDSAP521, _ = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+
+func algNames(algs []Algorithm) []string {
+	names := make([]string, 0, len(algs))
+	for _, alg := range algs {
+		names = append(names, string(alg.RFC)+"|"+string(alg.Draft))
+	}
+	return names
+}
+
+func Test_matchingAlgorithms(t *testing.T) {
+	tests := []struct {
+		name string
+		pub  crypto.PublicKey
+		want []string
+	}{
+		{
+			name: "empty",
+			pub:  nil,
+			want: []string{},
+		},
+		{
+			name: "rsa 2048",
+			pub:  pubKeyRSA,
+			want: []string{"rsa-v1_5-sha256|rsa-sha256", "rsa-pss-sha512|", "rsa-pss-sha384|", "rsa-pss-sha256|"},
+		},
+		{
+			name: "ecdsa P-256",
+			pub:  pubKeyECDSA,
+			want: []string{"ecdsa-p256-sha256|ecdsa-sha256"},
+		},
+		{
+			name: "ecdsa P-521",
+			pub:  &prvKeyECDSAP521.PublicKey,
+			want: []string{"ecdsa-p521-sha512|ecdsa-sha512"},
+		},
+		{
+			name: "ecdsa P-224",
+			pub:  &prvKeyECDSAP224.PublicKey,
+			want: []string{},
+		},
+		{
+			name: "ed25519",
+			pub:  pubKeyEd25519,
+			want: []string{"ed25519|ed25519"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := algNames(matchingAlgorithms(tt.pub)); !cmp.Equal(got, tt.want) {
+				t.Errorf("matchingAlgorithms() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	t.Cleanup(func() {
+		algorithms.Lock()
+		algorithms.list = defaultAlgorithms()
+		algorithms.Unlock()
+	})
+
+	RegisterAlgorithm(Algorithm{Draft: draft.RSA_SHA512, Key: RSAKeys(256)})
+	// NOTE(marius): algorithms without a key matcher are ignored
+	RegisterAlgorithm(Algorithm{RFC: httpsig.RsaPssSha512})
+
+	// NOTE(marius): the new algorithm replaces the draft name of the "rsa-v1_5-sha512|rsa-sha512" one, taking its place
+	want := []string{"rsa-v1_5-sha256|rsa-sha256", "|rsa-sha512", "rsa-pss-sha512|", "rsa-pss-sha384|", "rsa-pss-sha256|"}
+	if got := algNames(matchingAlgorithms(pubKeyRSA)); !cmp.Equal(got, want) {
+		t.Errorf("matchingAlgorithms() = %s", cmp.Diff(want, got))
+	}
+	if got := len(algorithms.list); got != len(defaultAlgorithms())+1 {
+		t.Errorf("RegisterAlgorithm() registered %d algorithms, want %d", got, len(defaultAlgorithms())+1)
+	}
+	wantDraft := []draft.Algorithm{draft.RSA_SHA256, draft.RSA_SHA512}
+	if got := compatibleDraftVerifyAlgorithms(pubKeyRSA); !cmp.Equal(got, wantDraft) {
+		t.Errorf("compatibleDraftVerifyAlgorithms() = %s", cmp.Diff(wantDraft, got))
+	}
+
+	// NOTE(marius): replacing both names of an existing algorithm removes it
+	RegisterAlgorithm(Algorithm{RFC: httpsig.RsaPkcs1v15Sha256, Draft: draft.RSA_SHA256, Key: RSAKeys(128)})
+	want = []string{"|rsa-sha512", "rsa-pss-sha512|", "rsa-pss-sha384|", "rsa-pss-sha256|"}
+	if got := algNames(matchingAlgorithms(pubKeyRSA)); !cmp.Equal(got, want) {
+		t.Errorf("matchingAlgorithms() = %s", cmp.Diff(want, got))
+	}
+	if got := len(algorithms.list); got != len(defaultAlgorithms())+1 {
+		t.Errorf("RegisterAlgorithm() registered %d algorithms, want %d", got, len(defaultAlgorithms())+1)
+	}
+	if supportsAlgorithm(pubKeyRSA, httpsig.RsaPkcs1v15Sha256) {
+		t.Errorf("supportsAlgorithm() = true for the replaced %s algorithm", httpsig.RsaPkcs1v15Sha256)
+	}
+}
+
+func Test_pssAlgorithms(t *testing.T) {
+	tests := []struct {
+		name string
+		pub  crypto.PublicKey
+		want []httpsig.SignatureAlgorithm
+	}{
+		{
+			name: "rsa",
+			pub:  pubKeyRSA,
+			want: []httpsig.SignatureAlgorithm{httpsig.RsaPssSha512, httpsig.RsaPssSha384, httpsig.RsaPssSha256},
+		},
+		{
+			name: "ecdsa",
+			pub:  pubKeyECDSA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pssAlgorithms(tt.pub); !cmp.Equal(got, tt.want) {
+				t.Errorf("pssAlgorithms() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func Test_rsaPSSAlgorithm(t *testing.T) {
+	sign := func(alg httpsig.SignatureAlgorithm) []byte {
+		h := pssHash(alg)
+		if alg == "" {
+			h = crypto.SHA256
+		}
+		d := h.New()
+		d.Write([]byte("test"))
+		var sig []byte
+		var err error
+		if alg == "" {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, prvKeyRSA1, h, d.Sum(nil))
+		} else {
+			sig, err = rsa.SignPSS(rand.Reader, prvKeyRSA1, h, d.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			t.Fatalf("unable to sign: %s", err)
+		}
+		return sig
+	}
+	pss := []httpsig.SignatureAlgorithm{httpsig.RsaPssSha512, httpsig.RsaPssSha384, httpsig.RsaPssSha256}
+	tests := []struct {
+		name string
+		sig  []byte
+		algs []httpsig.SignatureAlgorithm
+		want httpsig.SignatureAlgorithm
+	}{
+		{name: "PKCS #1 v1.5", sig: sign(""), algs: pss},
+		{name: "RSA-PSS SHA-256", sig: sign(httpsig.RsaPssSha256), algs: pss, want: httpsig.RsaPssSha256},
+		{name: "RSA-PSS SHA-384", sig: sign(httpsig.RsaPssSha384), algs: pss, want: httpsig.RsaPssSha384},
+		{name: "RSA-PSS SHA-512", sig: sign(httpsig.RsaPssSha512), algs: pss, want: httpsig.RsaPssSha512},
+		{name: "RSA-PSS not allowed", sig: sign(httpsig.RsaPssSha512), algs: pss[1:]},
+		{name: "RSA-PSS with maximum salt length", sig: func() []byte {
+			d := sha256.Sum256([]byte("test"))
+			sig, _ := rsa.SignPSS(rand.Reader, prvKeyRSA1, crypto.SHA256, d[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+			return sig
+		}(), algs: pss},
+		{name: "wrong size", sig: sign(httpsig.RsaPssSha512)[1:], algs: pss},
+		{name: "garbage", sig: make([]byte, 256), algs: pss},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rsaPSSAlgorithm(&prvKeyRSA1.PublicKey, tt.sig, tt.algs); got != tt.want {
+				t.Errorf("rsaPSSAlgorithm() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-ap/errors"
+)
+
+// Confirmation holds the keys that an access token is bound to, as described in RFC7800.
+// The token can be used only by the clients that prove the possession of these keys.
+type Confirmation struct {
+	// JKT is the RFC7638 thumbprint of the DPoP key, see RFC9449.
+	JKT string `json:"jkt,omitempty"`
+	// X5T is the SHA-256 thumbprint of the TLS client certificate, see RFC8705.
+	X5T string `json:"x5t#S256,omitempty"`
+}
+
+func (c Confirmation) empty() bool {
+	return c.JKT == "" && c.X5T == ""
+}
+
+// confirmationStore is implemented by the OAuth2 storage backends which keep the keys that the opaque
+// access tokens are bound to.
+type confirmationStore interface {
+	// LoadConfirmation returns the keys the token is bound to.
+	// It must return a NotFound error, or an empty Confirmation, for tokens that are not bound to any key,
+	// any other error is considered a storage failure, and the requests using the token get rejected.
+	LoadConfirmation(token string) (Confirmation, error)
+}
+
+// confirmation returns the keys the access token that authorized the request is bound to.
+func (r VerificationResult) confirmation() Confirmation {
+	return Confirmation{JKT: r.KeyThumbprint, X5T: r.CertificateThumbprint}
+}
+
+// loadConfirmation returns the keys that the opaque tok access token is bound to, when the storage keeps track of them.
+func (k oauthVerifier) loadConfirmation(tok string) (Confirmation, error) {
+	st, ok := k.st.(confirmationStore)
+	if !ok {
+		return Confirmation{}, nil
+	}
+	cnf, err := st.LoadConfirmation(tok)
+	if err != nil && !errors.IsNotFound(err) {
+		return Confirmation{}, classify(ErrMisconfigured, "", "", errors.Annotatef(err, "unable to load access token binding"))
+	}
+	return cnf, nil
+}
+
+// checkBinding verifies that the request proves the possession of the keys the access token is bound to:
+// tokens bound to a DPoP key need a valid proof made with that key, and can't be used as bearer tokens,
+// and tokens bound to a client certificate need to be used over a TLS connection authenticated with it.
+func (k oauthVerifier) checkBinding(r *http.Request, scheme, tok string, cnf Confirmation) error {
+	if cnf.X5T != "" {
+		if err := checkCertificate(r, cnf.X5T); err != nil {
+			return err
+		}
+	}
+	if scheme == schemeDPoP {
+		return k.checkDPoP(r, tok, cnf.JKT)
+	}
+	if cnf.JKT != "" {
+		return errUnboundToken(errors.Newf("access token bound to a DPoP key was used as a bearer token"))
+	}
+	return nil
+}
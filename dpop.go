@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dadrus/httpsig"
+	"github.com/go-ap/errors"
+)
+
+const schemeDPoP = "DPoP"
+
+// dpopAlgorithms are the JWS algorithms we accept for DPoP proofs, they are advertised in the challenges.
+const dpopAlgorithms = "ES256 ES384 ES512 PS256 PS384 PS512 RS256 RS384 RS512 EdDSA"
+
+// dpopClaims are the claims of a DPoP proof, as described in RFC9449 section 4.2.
+type dpopClaims struct {
+	ID              string `json:"jti"`
+	Method          string `json:"htm"`
+	URI             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath,omitempty"`
+}
+
+type dpopHeader struct {
+	jwtHeader
+	JWK *jwk `json:"jwk,omitempty"`
+}
+
+// errInvalidProof returns an Unauthorized error with the RFC9449 invalid_dpop_proof challenge, classified as kind.
+func errInvalidProof(kind ErrorKind, err error) error {
+	ch := `DPoP error="invalid_dpop_proof", algs="` + dpopAlgorithms + `"`
+	return classify(kind, "", "", errors.NewUnauthorized(err, "invalid DPoP proof").Challenge(ch))
+}
+
+// errUnboundToken is returned when the access token is not bound to the DPoP key used for the request.
+func errUnboundToken(err error) error {
+	ch := `DPoP error="invalid_token", algs="` + dpopAlgorithms + `"`
+	return classify(ErrBadSignature, "", "", errors.NewUnauthorized(err, "invalid access token").Challenge(ch))
+}
+
+// checkDPoP validates the DPoP proof of the request, as described in RFC9449 section 4.3, and that
+// the access token is bound to the key the proof was made with.
+func (k oauthVerifier) checkDPoP(r *http.Request, tok, jkt string) error {
+	proofs := r.Header.Values(schemeDPoP)
+	if len(proofs) != 1 || !isJWT(proofs[0]) {
+		return errInvalidProof(ErrMissingCredentials, errors.Newf("the request must contain exactly one DPoP proof"))
+	}
+	parts := strings.Split(proofs[0], ".")
+
+	header := dpopHeader{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return errInvalidProof(ErrMalformed, errors.Annotatef(err, "invalid DPoP proof header"))
+	}
+	if !strings.EqualFold(header.Typ, "dpop+jwt") || header.JWK == nil {
+		return errInvalidProof(ErrMalformed, errors.Newf("invalid DPoP proof header"))
+	}
+	pub, err := header.JWK.publicKey()
+	if err != nil || pub == nil {
+		return errInvalidProof(ErrMalformed, errors.Newf("invalid DPoP proof key"))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errInvalidProof(ErrMalformed, errors.Annotatef(err, "invalid DPoP proof signature encoding"))
+	}
+	if err = verifyJWS(header.Alg, pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return errInvalidProof(ErrBadSignature, err)
+	}
+
+	claims := dpopClaims{}
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return errInvalidProof(ErrMalformed, errors.Annotatef(err, "invalid DPoP proof claims"))
+	}
+	if claims.ID == "" {
+		return errInvalidProof(ErrMalformed, errors.Newf("missing jti claim"))
+	}
+	if claims.Method != r.Method {
+		return errInvalidProof(ErrBadSignature, errors.Newf("proof made for a %s request", claims.Method))
+	}
+	if !sameHTTPURI(claims.URI, requestURI(r, k.proxies, k.forwarded)) {
+		return errInvalidProof(ErrBadSignature, errors.Newf("proof made for a different URI: %s", claims.URI))
+	}
+	iat := time.Unix(claims.IssuedAt, 0)
+	now := k.now()
+	if iat.After(now.Add(sigValidDeltaDuration+k.leeway)) || iat.Before(now.Add(-sigMaxAgeDuration-k.leeway)) {
+		return errInvalidProof(ErrExpired, errors.Newf("proof issued at %s is outside the acceptable window", iat.UTC().Format(time.RFC3339)))
+	}
+	if claims.AccessTokenHash != accessTokenHash(tok) {
+		return errInvalidProof(ErrBadSignature, errors.Newf("proof made for a different access token"))
+	}
+
+	thumb, err := header.JWK.thumbprint()
+	if err != nil {
+		return errInvalidProof(ErrMalformed, err)
+	}
+	if jkt == "" || jkt != thumb {
+		return errUnboundToken(errors.Newf("access token is not bound to the DPoP proof key"))
+	}
+
+	if k.ncFn == nil {
+		return nil
+	}
+	n := httpsig.NonceValue{Present: true, Value: nonceKey(schemeDPoP + "\n" + claims.ID)}
+	if err = k.ncFn.CheckNonce(r.Context(), n); err != nil {
+		return errInvalidProof(ErrReplayed, errors.Annotatef(err, "DPoP proof replay detected"))
+	}
+	return nil
+}
+
+// accessTokenHash returns the value of the "ath" claim corresponding to the tok access token.
+func accessTokenHash(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// thumbprint returns the RFC7638 SHA-256 thumbprint of the key.
+func (k jwk) thumbprint() (string, error) {
+	var members map[string]string
+	switch k.Kty {
+	case "RSA":
+		members = map[string]string{"e": k.E, "kty": k.Kty, "n": k.N}
+	case "EC":
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X, "y": k.Y}
+	case "OKP":
+		members = map[string]string{"crv": k.Crv, "kty": k.Kty, "x": k.X}
+	default:
+		return "", errors.Newf("unsupported key type %q", k.Kty)
+	}
+	// NOTE(marius): encoding/json sorts the map keys and doesn't add whitespace, which is what RFC7638 requires.
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// requestURI returns the URI of the request, without the query and fragment, as the client sent it.
+func requestURI(r *http.Request, proxies []netip.Prefix, headers ForwardedHeaders) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if h, s := forwardedHostScheme(r, proxies, headers); h != "" || s != "" {
+		if h != "" {
+			host = h
+		}
+		if s != "" {
+			scheme = s
+		}
+	}
+	return scheme + "://" + host + r.URL.EscapedPath()
+}
+
+// sameHTTPURI compares the "htu" claim with the request URI, ignoring the query and fragment of the claim,
+// after the syntax and scheme based normalization required by RFC9449 section 4.3.
+func sameHTTPURI(htu, uri string) bool {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	want, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return normalizeHTTPURI(u) == normalizeHTTPURI(want)
+}
+
+// normalizeHTTPURI returns the scheme, host and path of u, with the scheme and host lower cased, without
+// the default port of the scheme, and with "/" for an empty path, as described in RFC3986 section 6.2.
+func normalizeHTTPURI(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockBoundStore struct {
+	mockStore
+	cnf map[string]Confirmation
+	err error
+}
+
+func (ms mockBoundStore) LoadConfirmation(tok string) (Confirmation, error) {
+	if ms.err != nil {
+		return Confirmation{}, ms.err
+	}
+	if cnf, ok := ms.cnf[tok]; ok {
+		return cnf, nil
+	}
+	return Confirmation{}, errors.NotFoundf("not found")
+}
+
+func mockThumbprint(pub crypto.PublicKey) string {
+	jkt, _ := mockJWK("", pub).thumbprint()
+	return jkt
+}
+
+// mockProof builds a DPoP proof for the request, made with the key.
+func mockProof(t *testing.T, alg string, key crypto.Signer, claims dpopClaims) string {
+	header := dpopHeader{jwtHeader: jwtHeader{Alg: alg, Typ: "dpop+jwt"}}
+	jk := mockJWK("", key.Public())
+	jk.Use = ""
+	header.JWK = &jk
+	return signJWS(t, alg, key, header, claims)
+}
+
+// mockBoundJWT builds a JWT access token bound to the pub DPoP key.
+func mockBoundJWT(t *testing.T, now time.Time, pub crypto.PublicKey) string {
+	claims := mockClaims(now)
+	if pub != nil {
+		claims.Confirmation = &Confirmation{JKT: mockThumbprint(pub)}
+	}
+	return signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, claims)
+}
+
+func mockDPoPReq(scheme, tok string, proofs ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/~jdoe/inbox?page=1", nil)
+	r.Header.Set("Authorization", scheme+" "+tok)
+	for _, p := range proofs {
+		r.Header.Add("DPoP", p)
+	}
+	return r
+}
+
+func Test_jwk_thumbprint(t *testing.T) {
+	// NOTE(marius): the example from RFC7638 section 3.1
+	k := jwk{
+		Kty: "RSA",
+		Kid: "2011-04-29",
+		Alg: "RS256",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W" +
+			"-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbI" +
+			"SD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	if got, err := k.thumbprint(); err != nil || got != want {
+		t.Errorf("thumbprint() = %q, %v, want %q", got, err, want)
+	}
+}
+
+func Test_sameHTTPURI(t *testing.T) {
+	tests := []struct {
+		htu  string
+		uri  string
+		want bool
+	}{
+		{htu: "http://example.com/inbox", uri: "http://example.com/inbox", want: true},
+		{htu: "HTTP://Example.com/inbox?page=1#top", uri: "http://example.com/inbox", want: true},
+		{htu: "https://example.com/inbox", uri: "http://example.com/inbox", want: false},
+		{htu: "http://example.com/outbox", uri: "http://example.com/inbox", want: false},
+		{htu: "http://example.org/inbox", uri: "http://example.com/inbox", want: false},
+		{htu: "https://example.com:443/inbox", uri: "https://example.com/inbox", want: true},
+		{htu: "http://example.com/inbox", uri: "http://example.com:80/inbox", want: true},
+		{htu: "HTTPS://EXAMPLE.com:443/inbox", uri: "https://example.com/inbox", want: true},
+		{htu: "http://example.com:443/inbox", uri: "http://example.com/inbox", want: false},
+		{htu: "https://example.com:8443/inbox", uri: "https://example.com/inbox", want: false},
+		{htu: "https://[2001:DB8::1]:443/inbox", uri: "https://[2001:db8::1]/inbox", want: true},
+		{htu: "https://example.com", uri: "https://example.com/", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.htu, func(t *testing.T) {
+			if got := sameHTTPURI(tt.htu, tt.uri); got != tt.want {
+				t.Errorf("sameHTTPURI() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyResult_DPoP(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	htu := "http://example.com/~jdoe/inbox"
+	bound := mockBoundJWT(t, now, prvKeyECDSA.Public())
+	unbound := mockBoundJWT(t, now, nil)
+	proof := func(tok string, fn func(c *dpopClaims)) string {
+		c := dpopClaims{ID: "proof-1", Method: http.MethodGet, URI: htu, IssuedAt: now.Unix(), AccessTokenHash: accessTokenHash(tok)}
+		if fn != nil {
+			fn(&c)
+		}
+		return mockProof(t, "ES256", prvKeyECDSA, c)
+	}
+	actor := vocab.Actor{ID: "http://example.com/~jdoe"}
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		want    vocab.Actor
+		wantErr error
+	}{
+		{
+			name: "valid proof",
+			r:    mockDPoPReq(schemeDPoP, bound, proof(bound, nil)),
+			want: actor,
+		},
+		{
+			name:    "missing proof",
+			r:       mockDPoPReq(schemeDPoP, bound),
+			want:    AnonymousActor,
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name:    "multiple proofs",
+			r:       mockDPoPReq(schemeDPoP, bound, proof(bound, nil), proof(bound, nil)),
+			want:    AnonymousActor,
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name:    "wrong method",
+			r:       mockDPoPReq(schemeDPoP, bound, proof(bound, func(c *dpopClaims) { c.Method = http.MethodPost })),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "wrong URI",
+			r:       mockDPoPReq(schemeDPoP, bound, proof(bound, func(c *dpopClaims) { c.URI = "http://example.com/~jdoe/outbox" })),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "stale proof",
+			r:       mockDPoPReq(schemeDPoP, bound, proof(bound, func(c *dpopClaims) { c.IssuedAt = now.Add(-sigMaxAgeDuration - time.Hour).Unix() })),
+			want:    AnonymousActor,
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "missing jti",
+			r:       mockDPoPReq(schemeDPoP, bound, proof(bound, func(c *dpopClaims) { c.ID = "" })),
+			want:    AnonymousActor,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "proof for another token",
+			r:       mockDPoPReq(schemeDPoP, bound, proof(unbound, nil)),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name: "proof made with another key",
+			r: mockDPoPReq(schemeDPoP, bound, mockProof(t, "EdDSA", prvKeyEd25519, dpopClaims{
+				ID: "proof-1", Method: http.MethodGet, URI: htu, IssuedAt: now.Unix(), AccessTokenHash: accessTokenHash(bound),
+			})),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "unbound token with proof",
+			r:       mockDPoPReq(schemeDPoP, unbound, proof(unbound, nil)),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "bound token used as bearer",
+			r:       mockDPoPReq("Bearer", bound),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name: "unbound token used as bearer",
+			r:    mockDPoPReq("Bearer", unbound),
+			want: actor,
+		},
+	}
+	for _, tt := range tests {
+		s := OAuth2(
+			WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+			WithJWTAccessTokens(jwks{"ed25519": prvKeyEd25519.Public()}, "https://example.com", ""),
+			WithClock(func() time.Time { return now }),
+		)
+		t.Run(tt.name, func(t *testing.T) {
+			verifierTest(s, tt.r, tt.want, tt.wantErr)(t)
+			if tt.wantErr == nil {
+				return
+			}
+			_, err := s.VerifyResult(tt.r)
+			if ch := errors.Challenge(err); !strings.HasPrefix(ch, schemeDPoP+" ") {
+				t.Errorf("VerifyResult() challenge = %q, want a DPoP one", ch)
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyResult_DPoPReplay(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tok := mockBoundJWT(t, now, prvKeyECDSA.Public())
+	proof := mockProof(t, "ES256", prvKeyECDSA, dpopClaims{
+		ID:              "proof-1",
+		Method:          http.MethodGet,
+		URI:             "http://example.com/~jdoe/inbox",
+		IssuedAt:        now.Unix(),
+		AccessTokenHash: accessTokenHash(tok),
+	})
+	fns := []InitFn{
+		WithStorage(st()),
+		WithJWTAccessTokens(jwks{"ed25519": prvKeyEd25519.Public()}, "https://example.com", ""),
+		WithClock(func() time.Time { return now }),
+	}
+
+	tests := []struct {
+		name string
+		v    interface {
+			VerifyResult(*http.Request) (VerificationResult, error)
+		}
+	}{
+		{name: "OAuth2", v: OAuth2(fns...)},
+		{name: "Verifier", v: Verifier(fns...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.v.VerifyResult(mockDPoPReq(schemeDPoP, tok, proof)); err != nil {
+				t.Fatalf("VerifyResult() unexpected error = %v", err)
+			}
+			if _, err := tt.v.VerifyResult(mockDPoPReq(schemeDPoP, tok, proof)); !errors.Is(err, ErrReplayed) {
+				t.Errorf("VerifyResult() for replayed proof error = %v, want %v", err, ErrReplayed)
+			}
+		})
+	}
+}
+
+func Test_syncedNonceStore_leeway(t *testing.T) {
+	nc, ok := Config(WithLeeway(time.Minute)).ncFn.(*syncedNonceStore)
+	if !ok {
+		t.Fatalf("Config() default nonce store = %T", Config().ncFn)
+	}
+	if want := nonceTTL() + 2*time.Minute; nc.expiry() != want {
+		t.Errorf("syncedNonceStore expiry = %s, want %s", nc.expiry(), want)
+	}
+}
+
+func TestOAuth2_VerifyResult_DPoPOpaqueToken(t *testing.T) {
+	actor := mockActor()
+	jkt := mockThumbprint(prvKeyECDSA.Public())
+	s := OAuth2(WithStorage(mockBoundStore{
+		mockStore: st(&actor, mockAccess("test", defaultClient)),
+		cnf:       map[string]Confirmation{"test": {JKT: jkt}},
+	}))
+
+	proof := mockProof(t, "ES256", prvKeyECDSA, dpopClaims{
+		ID:              "proof-1",
+		Method:          http.MethodGet,
+		URI:             "http://example.com/~jdoe/inbox",
+		IssuedAt:        time.Now().Unix(),
+		AccessTokenHash: accessTokenHash("test"),
+	})
+	got, err := s.VerifyResult(mockDPoPReq(schemeDPoP, "test", proof))
+	if err != nil {
+		t.Fatalf("VerifyResult() unexpected error = %v", err)
+	}
+	if got.KeyThumbprint != jkt {
+		t.Errorf("VerifyResult() key thumbprint = %q, want %q", got.KeyThumbprint, jkt)
+	}
+	if !cmp.Equal(got.Actor, actor, EquateItems) {
+		t.Errorf("VerifyResult() actor = %s", cmp.Diff(actor, got.Actor, EquateItems))
+	}
+
+	if _, err = s.VerifyResult(mockDPoPReq("Bearer", "test")); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyResult() for bound token used as bearer error = %v, want %v", err, ErrBadSignature)
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/dadrus/httpsig"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// ErrorKind classifies the reasons for which the authorization of a request failed.
+// The kinds can be used as targets for errors.Is.
+type ErrorKind string
+
+const (
+	// ErrMissingCredentials is returned when the request doesn't contain a signature or a token.
+	ErrMissingCredentials ErrorKind = "missing credentials"
+	// ErrMalformed is returned when the signature or the token can't be parsed.
+	ErrMalformed ErrorKind = "malformed credentials"
+	// ErrUnknownKey is returned when the key, or the access token, used by the request is not known.
+	ErrUnknownKey ErrorKind = "unknown key"
+	// ErrKeyFetch is returned when the key could not be loaded from the remote server.
+	ErrKeyFetch ErrorKind = "unable to fetch key"
+	// ErrBadSignature is returned when the signature doesn't match the request.
+	ErrBadSignature ErrorKind = "invalid signature"
+	// ErrExpired is returned when the signature or the token is expired, or not valid yet.
+	ErrExpired ErrorKind = "expired credentials"
+	// ErrRevoked is returned when the access token, or the OAuth2 client it was issued to, has been revoked.
+	ErrRevoked ErrorKind = "revoked credentials"
+	// ErrReplayed is returned when the request is a replay of one that was already authorized.
+	ErrReplayed ErrorKind = "replayed request"
+	// ErrPolicy is returned when the key or algorithm is not allowed by the KeyPolicy.
+	ErrPolicy ErrorKind = "policy violation"
+	// ErrInsufficientScope is returned when the access token wasn't granted the scopes required by the request.
+	ErrInsufficientScope ErrorKind = "insufficient scope"
+	// ErrMisconfigured is returned when the verifier is missing its storage or client.
+	ErrMisconfigured ErrorKind = "misconfigured verifier"
+)
+
+func (k ErrorKind) Error() string {
+	return string(k)
+}
+
+// StatusCode returns the HTTP status code of the response for a request that failed authorization.
+func (k ErrorKind) StatusCode() int {
+	switch k {
+	case ErrMalformed:
+		return http.StatusBadRequest
+	case ErrPolicy, ErrInsufficientScope:
+		return http.StatusForbidden
+	case ErrKeyFetch:
+		return http.StatusBadGateway
+	case ErrMisconfigured:
+		return http.StatusInternalServerError
+	}
+	return http.StatusUnauthorized
+}
+
+// VerificationError is the error returned by the verifiers, it holds the kind of the failure
+// together with the key and actor involved, when they are known.
+type VerificationError struct {
+	Kind  ErrorKind
+	KeyID string
+	Actor vocab.IRI
+	Err   error
+}
+
+func (e *VerificationError) Error() string {
+	if e.Err == nil {
+		return string(e.Kind)
+	}
+	return e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// classify wraps err in a VerificationError of kind.
+// If err already contains a VerificationError, its kind is kept, as it was determined closer to where the failure happened.
+func classify(kind ErrorKind, keyID string, actor vocab.IRI, err error) error {
+	if err == nil {
+		return nil
+	}
+	inner := new(VerificationError)
+	if errors.As(err, &inner) {
+		kind = inner.Kind
+		if keyID == "" {
+			keyID = inner.KeyID
+		}
+		if actor == "" {
+			actor = inner.Actor
+		}
+	}
+	return &VerificationError{Kind: kind, KeyID: keyID, Actor: actor, Err: err}
+}
+
+// keyLoadKind classifies the errors returned when loading public keys.
+func keyLoadKind(err error) ErrorKind {
+	switch {
+	case errors.IsNotFound(err), errors.IsGone(err):
+		return ErrUnknownKey
+	}
+	return ErrKeyFetch
+}
+
+// rfcErrorKind classifies the errors returned by the RFC9421 verifier.
+func rfcErrorKind(err error) ErrorKind {
+	noSig := new(httpsig.NoApplicableSignatureError)
+	switch {
+	case errors.As(err, &noSig):
+		return ErrMissingCredentials
+	case errors.Is(err, httpsig.ErrValidity):
+		return ErrExpired
+	case errors.Is(err, httpsig.ErrInvalidSignature):
+		return ErrBadSignature
+	}
+	return ErrMalformed
+}
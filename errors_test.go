@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/dadrus/httpsig"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestErrorKind_StatusCode(t *testing.T) {
+	tests := []struct {
+		kind ErrorKind
+		want int
+	}{
+		{kind: ErrMissingCredentials, want: http.StatusUnauthorized},
+		{kind: ErrMalformed, want: http.StatusBadRequest},
+		{kind: ErrUnknownKey, want: http.StatusUnauthorized},
+		{kind: ErrKeyFetch, want: http.StatusBadGateway},
+		{kind: ErrBadSignature, want: http.StatusUnauthorized},
+		{kind: ErrExpired, want: http.StatusUnauthorized},
+		{kind: ErrReplayed, want: http.StatusUnauthorized},
+		{kind: ErrRevoked, want: http.StatusUnauthorized},
+		{kind: ErrPolicy, want: http.StatusForbidden},
+		{kind: ErrInsufficientScope, want: http.StatusForbidden},
+		{kind: ErrMisconfigured, want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			if got := tt.kind.StatusCode(); got != tt.want {
+				t.Errorf("StatusCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_classify(t *testing.T) {
+	if err := classify(ErrMalformed, "", "", nil); err != nil {
+		t.Errorf("classify() expected nil error for nil input, got %s", err)
+	}
+
+	inner := classify(ErrReplayed, "http://example.com/~jdoe#main", "", errors.Newf("nonce already seen"))
+	err := classify(ErrBadSignature, "", "http://example.com/~jdoe", errors.Annotatef(inner, "verification failed"))
+
+	if !errors.Is(err, ErrReplayed) {
+		t.Errorf("classify() expected error to keep the inner kind %s", ErrReplayed)
+	}
+	if errors.Is(err, ErrBadSignature) {
+		t.Errorf("classify() expected error to not be %s", ErrBadSignature)
+	}
+	ve := new(VerificationError)
+	if !errors.As(err, &ve) {
+		t.Fatalf("classify() expected a %T", ve)
+	}
+	if ve.KeyID != "http://example.com/~jdoe#main" {
+		t.Errorf("classify() KeyID = %s", ve.KeyID)
+	}
+	if ve.Actor != "http://example.com/~jdoe" {
+		t.Errorf("classify() Actor = %s", ve.Actor)
+	}
+	if want := "verification failed: nonce already seen"; err.Error() != want {
+		t.Errorf("classify() message = %q, want %q", err.Error(), want)
+	}
+}
+
+func Test_rfcErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{
+			name: "no signature",
+			err:  errors.Annotatef(new(httpsig.NoApplicableSignatureError), "verification failed"),
+			want: ErrMissingCredentials,
+		},
+		{
+			name: "expired",
+			err:  fmt.Errorf("%w: %w: signature too old", httpsig.ErrVerificationFailed, httpsig.ErrValidity),
+			want: ErrExpired,
+		},
+		{
+			name: "bad signature",
+			err:  fmt.Errorf("%w: %w", httpsig.ErrVerificationFailed, httpsig.ErrInvalidSignature),
+			want: ErrBadSignature,
+		},
+		{
+			name: "malformed",
+			err:  fmt.Errorf("%w: %w: unexpected signature parameters format", httpsig.ErrVerificationFailed, httpsig.ErrMalformedData),
+			want: ErrMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rfcErrorKind(tt.err); got != tt.want {
+				t.Errorf("rfcErrorKind() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_httpSigVerifier_VerifyRFCSignature_errorKinds(t *testing.T) {
+	actor := mockRFCActor(prvKeyECDSA, "http://example.com/~jdoe#main")
+	loader := &multiKeyLoader{actors: map[string]vocab.Actor{string(actor.PublicKey.ID): actor}}
+	key := httpsig.Key{KeyID: string(actor.PublicKey.ID), Algorithm: httpsig.EcdsaP256Sha256, Key: prvKeyECDSA}
+
+	tampered := signedReq(t, key)
+	tampered.Method = http.MethodDelete
+
+	unknown := signedReq(t, httpsig.Key{KeyID: "http://example.com/~unknown#main", Algorithm: httpsig.EcdsaP256Sha256, Key: prvKeyECDSA})
+
+	tests := []struct {
+		name      string
+		req       *http.Request
+		policy    KeyPolicy
+		wantKind  ErrorKind
+		wantKeyID string
+	}{
+		{
+			name:     "no signature",
+			req:      mockGetReq(),
+			wantKind: ErrMissingCredentials,
+		},
+		{
+			name:     "signature input without signature",
+			req:      mockGetReq(url.Values{"Signature-Input": {`sig1=("@method");keyid="test"`}}),
+			wantKind: ErrMalformed,
+		},
+		{
+			name:      "bad signature",
+			req:       tampered,
+			wantKind:  ErrBadSignature,
+			wantKeyID: string(actor.PublicKey.ID),
+		},
+		{
+			name:      "unknown key",
+			req:       unknown,
+			wantKind:  ErrUnknownKey,
+			wantKeyID: "http://example.com/~unknown#main",
+		},
+		{
+			name:      "policy violation",
+			req:       signedReq(t, key),
+			policy:    KeyPolicy{Algorithms: []string{string(httpsig.Ed25519)}},
+			wantKind:  ErrPolicy,
+			wantKeyID: string(actor.PublicKey.ID),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := httpSigVerifier{loader: loader, policy: tt.policy, l: lw.Dev(lw.SetOutput(t.Output()))}
+			_, err := k.VerifyRFCSignature(tt.req)
+			if !errors.Is(err, tt.wantKind) {
+				t.Fatalf("VerifyRFCSignature() error = %v, expected kind %s", err, tt.wantKind)
+			}
+			ve := new(VerificationError)
+			if !errors.As(err, &ve) {
+				t.Fatalf("VerifyRFCSignature() expected a %T, got %T", ve, err)
+			}
+			if ve.KeyID != tt.wantKeyID {
+				t.Errorf("VerifyRFCSignature() error KeyID = %s, want %s", ve.KeyID, tt.wantKeyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ForwardedHeaders selects the headers the trusted proxies use for reporting the request of the client.
+type ForwardedHeaders uint8
+
+const (
+	// ForwardedHeader uses the "for", "host" and "proto" parameters of the RFC7239 Forwarded header.
+	ForwardedHeader ForwardedHeaders = iota
+	// XForwardedHeaders uses the X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers.
+	XForwardedHeaders
+)
+
+// WithTrustedProxies sets the networks of the reverse proxies that we trust to report the address of the client,
+// and the host and scheme it used, through the headers selected with WithForwardedHeaders.
+// The forwarding headers of requests coming from other addresses are ignored.
+func WithTrustedProxies(nets ...netip.Prefix) InitFn {
+	return func(c *config) {
+		c.proxies = nets
+	}
+}
+
+// WithForwardedHeaders sets the headers that the trusted proxies use, the default is the RFC7239 Forwarded header.
+// The headers of the other kind are ignored, as the proxies usually pass them along unchanged from the client.
+func WithForwardedHeaders(h ForwardedHeaders) InitFn {
+	return func(c *config) {
+		c.forwarded = h
+	}
+}
+
+// isTrustedProxy checks if the remote address of a request belongs to one of the trusted networks.
+func isTrustedProxy(remoteAddr string, nets []netip.Prefix) bool {
+	if len(nets) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(remoteAddr)
+		if err != nil {
+			return false
+		}
+		addr = ap.Addr()
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(nets, func(n netip.Prefix) bool { return n.Contains(addr) })
+}
+
+// forwardedHostScheme returns the host and scheme of the request as received by a trusted proxy, from
+// the Forwarded header, or from the X-Forwarded-Host and X-Forwarded-Proto ones, depending on headers.
+func forwardedHostScheme(r *http.Request, nets []netip.Prefix, headers ForwardedHeaders) (string, string) {
+	if !isTrustedProxy(r.RemoteAddr, nets) {
+		return "", ""
+	}
+	var host, scheme string
+	switch headers {
+	case XForwardedHeaders:
+		host = lastListValue(r.Header.Values("X-Forwarded-Host"))
+		scheme = lastListValue(r.Header.Values("X-Forwarded-Proto"))
+	default:
+		host, scheme = parseForwarded(r.Header.Values("Forwarded"))
+	}
+	return host, strings.ToLower(scheme)
+}
+
+// parseForwarded returns the host and proto parameters of the RFC7239 Forwarded header.
+// NOTE(marius): we use the last element of the list, as it's the one added by the proxy closest to us,
+// the previous ones can be set by anybody.
+func parseForwarded(values []string) (host, proto string) {
+	elements := strings.Split(strings.Join(values, ","), ",")
+	last := strings.TrimSpace(elements[len(elements)-1])
+	for _, pair := range strings.Split(last, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "host":
+			host = val
+		case "proto":
+			proto = val
+		}
+	}
+	return host, proto
+}
+
+// lastListValue returns the last element of a comma separated header value.
+func lastListValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	elements := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(elements[len(elements)-1])
+}
+
+// clientAddr returns the address of the client that made the request, as reported by a trusted proxy,
+// using the "for" parameter of the Forwarded header, or the X-Forwarded-For one, depending on headers.
+func clientAddr(r *http.Request, nets []netip.Prefix, headers ForwardedHeaders) string {
+	if isTrustedProxy(r.RemoteAddr, nets) {
+		switch headers {
+		case XForwardedHeaders:
+			if addr := lastListValue(r.Header.Values("X-Forwarded-For")); addr != "" {
+				return nodeAddr(addr)
+			}
+		default:
+			elements := strings.Split(strings.Join(r.Header.Values("Forwarded"), ","), ",")
+			for _, pair := range strings.Split(elements[len(elements)-1], ";") {
+				if key, val, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(key, "for") {
+					return nodeAddr(val)
+				}
+			}
+		}
+	}
+	return nodeAddr(r.RemoteAddr)
+}
+
+// nodeAddr returns the IP address of a RFC7239 node, which can be quoted, and have a port, like "[2001:db8::1]:4711".
+// The obfuscated identifiers and the "unknown" value are returned as they are.
+func nodeAddr(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if ap, err := netip.ParseAddrPort(node); err == nil {
+		return ap.Addr().Unmap().String()
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(node, "[]")); err == nil {
+		return addr.Unmap().String()
+	}
+	return node
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/dadrus/httpsig"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+var mockProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+func Test_isTrustedProxy(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		nets       []netip.Prefix
+		want       bool
+	}{
+		{
+			name:       "no trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+		},
+		{
+			name:       "invalid address",
+			remoteAddr: "example.com:1234",
+			nets:       mockProxies,
+		},
+		{
+			name:       "trusted with port",
+			remoteAddr: "10.0.0.1:1234",
+			nets:       mockProxies,
+			want:       true,
+		},
+		{
+			name:       "trusted without port",
+			remoteAddr: "10.1.2.3",
+			nets:       mockProxies,
+			want:       true,
+		},
+		{
+			name:       "trusted ipv6",
+			remoteAddr: "[::1]:1234",
+			nets:       mockProxies,
+			want:       true,
+		},
+		{
+			name:       "trusted ipv4 mapped ipv6",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			nets:       mockProxies,
+			want:       true,
+		},
+		{
+			name:       "untrusted",
+			remoteAddr: "192.0.2.1:1234",
+			nets:       mockProxies,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTrustedProxy(tt.remoteAddr, tt.nets); got != tt.want {
+				t.Errorf("isTrustedProxy() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_forwardedHostScheme(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    ForwardedHeaders
+		header     http.Header
+		wantHost   string
+		wantScheme string
+	}{
+		{
+			name:       "untrusted proxy",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"X-Forwarded-Host": {"example.com"}, "X-Forwarded-Proto": {"https"}},
+		},
+		{
+			name:       "x-forwarded headers",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"X-Forwarded-Host": {"example.com"}, "X-Forwarded-Proto": {"HTTPS"}},
+			wantHost:   "example.com",
+			wantScheme: "https",
+		},
+		{
+			name:       "x-forwarded headers, last value",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"X-Forwarded-Host": {"attacker.example, example.com"}},
+			wantHost:   "example.com",
+		},
+		{
+			name:       "x-forwarded headers, forwarded header ignored",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    XForwardedHeaders,
+			header: http.Header{
+				"Forwarded":         {`for=192.0.2.60;host=attacker.example;proto=http`},
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantScheme: "https",
+		},
+		{
+			name:       "forwarded header",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {`for=192.0.2.60;proto=http;host=attacker.example, for="[2001:db8:cafe::17]";Proto=https;Host="example.com"`}},
+			wantHost:   "example.com",
+			wantScheme: "https",
+		},
+		{
+			name:       "forwarded header, x-forwarded headers ignored",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":         {`for=192.0.2.60;host=example.com`},
+				"X-Forwarded-Host":  {"attacker.example"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantHost: "example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockGetReq()
+			r.RemoteAddr = tt.remoteAddr
+			r.Header = tt.header
+			host, scheme := forwardedHostScheme(r, mockProxies, tt.headers)
+			if host != tt.wantHost {
+				t.Errorf("forwardedHostScheme() host = %q, want %q", host, tt.wantHost)
+			}
+			if scheme != tt.wantScheme {
+				t.Errorf("forwardedHostScheme() scheme = %q, want %q", scheme, tt.wantScheme)
+			}
+		})
+	}
+}
+
+func Test_clientAddr(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    ForwardedHeaders
+		header     http.Header
+		want       string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "untrusted proxy",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"X-Forwarded-For": {"192.0.2.60"}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "x-forwarded-for, last value",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.7, 192.0.2.60"}},
+			want:       "192.0.2.60",
+		},
+		{
+			name:       "x-forwarded-for, forwarded header ignored",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"Forwarded": {`for=203.0.113.7`}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "forwarded header",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {`for=203.0.113.7, for="[2001:db8:cafe::17]";proto=https`}},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "forwarded header, IPv6 with port",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {`for="[2001:db8::1]:4711"`}},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "forwarded header, IPv4 with port",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {`for="192.0.2.1:1234";proto=https`}},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "forwarded header, obfuscated",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {`for=_hidden`}},
+			want:       "_hidden",
+		},
+		{
+			name:       "forwarded header, x-forwarded-for ignored",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "x-forwarded-for with port",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    XForwardedHeaders,
+			header:     http.Header{"X-Forwarded-For": {"[2001:db8::1]:4711"}},
+			want:       "2001:db8::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mockGetReq()
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != nil {
+				r.Header = tt.header
+			}
+			if got := clientAddr(r, mockProxies, tt.headers); got != tt.want {
+				t.Errorf("clientAddr() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_httpSigVerifier_VerifyRFCSignature_trustedProxy(t *testing.T) {
+	actor := mockRFCActor(prvKeyECDSA, "http://example.com/~jdoe#main")
+	loader := &multiKeyLoader{actors: map[string]vocab.Actor{string(actor.PublicKey.ID): actor}}
+	key := httpsig.Key{KeyID: string(actor.PublicKey.ID), Algorithm: httpsig.EcdsaP256Sha256, Key: prvKeyECDSA}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       vocab.Actor
+		wantErr    bool
+	}{
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			want:       actor,
+		},
+		{
+			name:       "untrusted proxy",
+			remoteAddr: "192.0.2.1:1234",
+			want:       AnonymousActor,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// NOTE(marius): the request was signed for example.com, and it's received by the backend behind the proxy
+			req := signedReq(t, key)
+			req.Host = "backend.local:8080"
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-Host", "example.com")
+
+			k := httpSigVerifier{loader: loader, proxies: mockProxies, forwarded: XForwardedHeaders, l: lw.Dev(lw.SetOutput(t.Output()))}
+			got, err := k.VerifyRFCSignature(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRFCSignature() error = %v, wantErr %t", err, tt.wantErr)
+				return
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyRFCSignature() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+			if req.Host != "backend.local:8080" {
+				t.Errorf("VerifyRFCSignature() modified the request host to %s", req.Host)
+			}
+		})
+	}
+}
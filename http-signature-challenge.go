@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/dadrus/httpsig"
+	"github.com/go-ap/errors"
+	draft "github.com/go-fed/httpsig"
+)
+
+// challengeLabel is the label under which we request a RFC9421 signature in the Accept-Signature header.
+const challengeLabel = "sig"
+
+// defaultChallengeComponents returns the components we ask clients to sign when no required components
+// have been configured: the method and target URI, and for requests with a body, its digest.
+func defaultChallengeComponents(r *http.Request) []string {
+	components := []string{"@method", "@target-uri"}
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		components = append(components, "content-digest")
+	}
+	return components
+}
+
+// challengeComponents returns the components we ask clients to sign: the required ones, or the default ones.
+func (k httpSigVerifier) challengeComponents(r *http.Request) []string {
+	if len(k.components) > 0 {
+		return k.components
+	}
+	return defaultChallengeComponents(r)
+}
+
+// Challenge adds to the response the headers that let a client know how to sign its requests
+// and returns err wrapped in an Unauthorized error carrying the draft-cavage challenge, similarly
+// to the "oauth2" challenge of the OAuth2 verifier:
+//
+// * For RFC9421 clients it adds an Accept-Signature header requesting the required components.
+// * For draft-cavage clients it adds a WWW-Authenticate header using the Signature scheme.
+func (k httpSigVerifier) Challenge(w http.ResponseWriter, r *http.Request, err error) error {
+	components := k.challengeComponents(r)
+
+	if acceptErr := acceptSignature(r, w.Header(), components); acceptErr != nil {
+		k.l.WithContext(lw.Ctx{"err": acceptErr.Error()}).Warnf("unable to build Accept-Signature header")
+	}
+
+	challenge := draftChallenge(r.Host, components)
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	return errors.NewUnauthorized(err, "Unauthorized").Challenge(challenge)
+}
+
+// noNonce is used for omitting the nonce from the Accept-Signature header, as we don't keep track
+// of the nonces we hand out, we only reject the ones we've already seen.
+var noNonce = httpsig.NonceGetterFunc(func(_ context.Context) (string, error) {
+	return "", nil
+})
+
+// acceptSignature adds the RFC9421 Accept-Signature header requesting a signature covering components.
+func acceptSignature(r *http.Request, hdr http.Header, components []string) error {
+	asb, err := httpsig.NewAcceptSignature(
+		httpsig.WithExpectedLabel(challengeLabel),
+		httpsig.WithExpectedNonce(noNonce),
+		httpsig.WithExpectedComponents(components...),
+		httpsig.WithExpectedCreatedTimestamp(true),
+		httpsig.WithExpectedExpiresTimestamp(false),
+	)
+	if err != nil {
+		return err
+	}
+	return asb.Build(r.Context(), hdr)
+}
+
+// draftChallenge builds the value of the WWW-Authenticate header for clients using draft-cavage signatures,
+// converting the RFC9421 components to the header names used by the draft.
+func draftChallenge(realm string, components []string) string {
+	headers := make([]string, 0, len(components)+1)
+	for _, component := range components {
+		h := draftHeaderName(component)
+		if h == "" || slices.Contains(headers, h) {
+			continue
+		}
+		headers = append(headers, h)
+	}
+	if !slices.Contains(headers, "date") {
+		// NOTE(marius): draft signatures don't have a created parameter that we can rely on,
+		// so we need the Date header for checking their freshness.
+		headers = append(headers, "date")
+	}
+	return fmt.Sprintf(`Signature realm="%s",headers="%s"`, realm, strings.Join(headers, " "))
+}
+
+// draftHeaderName maps a RFC9421 component identifier to its draft-cavage equivalent.
+// It returns an empty string for derived components that don't have one.
+func draftHeaderName(component string) string {
+	switch component {
+	case "@method", "@target-uri", "@request-target", "@path", "@query":
+		return draft.RequestTarget
+	case "@authority":
+		return "host"
+	case "content-digest":
+		return "digest"
+	}
+	if strings.HasPrefix(component, "@") {
+		return ""
+	}
+	return strings.ToLower(component)
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_httpSigVerifier_Challenge(t *testing.T) {
+	tests := []struct {
+		name             string
+		components       []string
+		req              *http.Request
+		wantAccept       string
+		wantAuthenticate string
+	}{
+		{
+			name:             "GET default components",
+			req:              mockGetReq(),
+			wantAccept:       `sig=("@method" "@target-uri");created`,
+			wantAuthenticate: `Signature realm="example.com",headers="(request-target) date"`,
+		},
+		{
+			name:             "POST default components",
+			req:              mockPostReq([]byte("{}")),
+			wantAccept:       `sig=("@method" "@target-uri" "content-digest");created`,
+			wantAuthenticate: `Signature realm="example.com",headers="(request-target) digest date"`,
+		},
+		{
+			name:             "configured components",
+			components:       []string{"@method", "@path", "@authority", "date", "content-type"},
+			req:              mockGetReq(),
+			wantAccept:       `sig=("@method" "@path" "@authority" "date" "content-type");created`,
+			wantAuthenticate: `Signature realm="example.com",headers="(request-target) host date content-type"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := httpSigVerifier{components: tt.components, l: lw.Dev(lw.SetOutput(t.Output()))}
+			w := httptest.NewRecorder()
+
+			verifyErr := errors.Newf("verification failed")
+			err := k.Challenge(w, tt.req, verifyErr)
+			if !errors.IsUnauthorized(err) {
+				t.Errorf("Challenge() expected Unauthorized error, got %v", err)
+			}
+			if got := errors.Challenge(err); got != tt.wantAuthenticate {
+				t.Errorf("Challenge() error challenge = %s", cmp.Diff(tt.wantAuthenticate, got))
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.wantAuthenticate {
+				t.Errorf("Challenge() WWW-Authenticate = %s", cmp.Diff(tt.wantAuthenticate, got))
+			}
+			if got := w.Header().Get("Accept-Signature"); got != tt.wantAccept {
+				t.Errorf("Challenge() Accept-Signature = %s", cmp.Diff(tt.wantAccept, got))
+			}
+		})
+	}
+}
+
+func Test_draftHeaderName(t *testing.T) {
+	tests := []struct {
+		component string
+		want      string
+	}{
+		{component: "@method", want: "(request-target)"},
+		{component: "@target-uri", want: "(request-target)"},
+		{component: "@authority", want: "host"},
+		{component: "@scheme", want: ""},
+		{component: "content-digest", want: "digest"},
+		{component: "Date", want: "date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.component, func(t *testing.T) {
+			if got := draftHeaderName(tt.component); got != tt.want {
+				t.Errorf("draftHeaderName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
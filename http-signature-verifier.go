@@ -2,12 +2,14 @@ package auth
 
 import (
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/ed25519"
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
 	"github.com/dadrus/httpsig"
@@ -17,113 +19,255 @@ import (
 )
 
 type httpSigVerifier struct {
-	loader keyLoader
-	ncFn   httpsig.NonceChecker
-	l      lw.Logger
+	loader     keyLoader
+	ncFn       httpsig.NonceChecker
+	l          lw.Logger
+	components []string
+	policy     KeyPolicy
+	secrets    SecretStore
+	service    vocab.Actor
+	proxies    []netip.Prefix
+	forwarded  ForwardedHeaders
+	clock      ClockFn
 }
 
 // HTTPSignature returns an HTTP-Signature validator for loading f
 func HTTPSignature(initFns ...InitFn) httpSigVerifier {
-	c := Config(initFns...)
-	v := httpSigVerifier{
-		loader: &localRemoteLoader{c: c.c, st: c.st},
-		ncFn:   c.ncFn,
-		l:      c.l,
+	return newHTTPSigVerifier(Config(initFns...))
+}
+
+func newHTTPSigVerifier(c config) httpSigVerifier {
+	return httpSigVerifier{
+		loader:     &localRemoteLoader{c: c.c, st: c.st, fetch: c.fetchPolicy},
+		ncFn:       c.ncFn,
+		l:          c.l,
+		components: c.components,
+		policy:     c.policy,
+		secrets:    c.secrets,
+		service:    c.service,
+		proxies:    c.proxies,
+		forwarded:  c.forwarded,
+		clock:      c.clock,
 	}
-	return v
 }
 
-var errInvalidRequest = errors.Newf("invalid request")
+func (k httpSigVerifier) now() time.Time {
+	if k.clock == nil {
+		return time.Now()
+	}
+	return k.clock()
+}
+
+var errInvalidRequest = &VerificationError{Kind: ErrMalformed, Err: errors.Newf("invalid request")}
 
 func (k httpSigVerifier) VerifyDraftSignature(r *http.Request) (vocab.Actor, error) {
+	res, err := k.VerifyDraftSignatureResult(r)
+	return res.Actor, err
+}
+
+// VerifyDraftSignatureResult checks for draft-cavage HTTP signatures and returns the actor that signed
+// the request together with the details of the signature.
+func (k httpSigVerifier) VerifyDraftSignatureResult(r *http.Request) (VerificationResult, error) {
 	if r == nil {
-		return AnonymousActor, errInvalidRequest
+		return anonymousResult(), errInvalidRequest
 	}
 	if k.loader == nil {
-		return AnonymousActor, errInvalidClient
+		return anonymousResult(), errInvalidClient
 	}
 
-	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
-		r.Host = forwardedHost
+	// NOTE(marius): the draft verifier modifies the request headers, so we work on a copy,
+	// which also holds the host reported by a trusted proxy.
+	r = r.Clone(r.Context())
+	if host, _ := forwardedHostScheme(r, k.proxies, k.forwarded); host != "" {
+		r.Host = host
 	}
 	v, err := draft.NewVerifier(r)
 	if err != nil {
-		return AnonymousActor, errors.NewBadRequest(err, "unable to initialize HTTP Signatures verifier")
+		if draftSignatureParams(r.Header) == nil {
+			err = errors.NewUnauthorized(err, "missing HTTP Signature").Challenge(draftChallenge(r.Host, k.challengeComponents(r)))
+			return anonymousResult(), classify(ErrMissingCredentials, "", "", err)
+		}
+		return anonymousResult(), classify(ErrMalformed, "", "", errors.NewBadRequest(err, "unable to initialize HTTP Signatures verifier"))
 	}
 
-	actor, key, err := k.loader.loadKey(v.KeyId())
+	keyID := v.KeyId()
+	params := draftSignatureParams(r.Header)
+	if err = k.checkDraftHeaders(params); err != nil {
+		// NOTE(marius): the request is signed, but the signature is too weak for our policy.
+		return anonymousResult(), classify(ErrPolicy, keyID, "", err)
+	}
+	if err = k.checkDraftFreshness(r, params); err != nil {
+		return anonymousResult(), classify(ErrExpired, keyID, "", err)
+	}
+	actor, key, err := k.loader.loadKey(r.Context(), keyID)
 	if err != nil {
-		return AnonymousActor, errors.Annotatef(err, "unable to load public key based on signature")
+		return anonymousResult(), classify(keyLoadKind(err), keyID, "", errors.Annotatef(err, "unable to load public key based on signature"))
 	}
 
 	pk, err := toCryptoPublicKey(*key)
 	if err != nil {
-		return AnonymousActor, errors.Annotatef(err, "invalid public key")
+		return anonymousResult(), classify(ErrUnknownKey, keyID, actor.ID, errors.Annotatef(err, "invalid public key"))
+	}
+	if err = k.policy.checkKey(pk); err != nil {
+		return anonymousResult(), classify(ErrPolicy, keyID, actor.ID, errors.Annotatef(err, "public key %s rejected", keyID))
 	}
 
-	algs := compatibleDraftVerifyAlgorithms(pk)
+	algs := k.policy.draftAlgorithms(compatibleDraftVerifyAlgorithms(pk))
+	if len(algs) == 0 {
+		return anonymousResult(), classify(ErrPolicy, keyID, actor.ID, errors.Newf("no allowed signature algorithm for public key %s", keyID))
+	}
 	errs := make([]error, 0, len(algs))
 	for _, algo := range algs {
 		if err = v.Verify(pk, algo); err != nil {
 			errs = append(errs, errors.Annotatef(err, "failed %s", algo))
 			continue
 		}
-		return actor, nil
+		if err = k.checkDraftReplay(r, keyID); err != nil {
+			return anonymousResult(), classify(ErrReplayed, keyID, actor.ID, err)
+		}
+		sig := draftSignature(draftSignatureParams(r.Header), string(algo))
+		sig.Actor = actor
+		res := VerificationResult{
+			Method:     MethodDraftSignature,
+			Actor:      actor,
+			Signatures: []Signature{sig},
+		}
+		return res, nil
+	}
+	return anonymousResult(), classify(ErrBadSignature, keyID, actor.ID, errors.Join(errs...))
+}
+
+// checkDraftReplay records the keyId and signature value pair of a draft signature in the nonce store.
+// A pair which has been seen before, while the signature is still considered fresh, means the request is a replay.
+// NOTE(marius): the verifiers created from a config always have a nonce store, see Config.
+func (k httpSigVerifier) checkDraftReplay(r *http.Request, keyID string) error {
+	if k.ncFn == nil {
+		return nil
 	}
-	return AnonymousActor, errors.Join(errs...)
+	sig := draftSignatureParams(r.Header)["signature"]
+	if sig == "" {
+		return nil
+	}
+	n := httpsig.NonceValue{Present: true, Value: nonceKey(keyID + "\n" + sig)}
+	if err := k.ncFn.CheckNonce(r.Context(), n); err != nil {
+		return errors.Annotatef(err, "signature replay detected for key %s", keyID)
+	}
+	return nil
+}
+
+// checkDraftHeaders returns an error if the draft-cavage signature doesn't cover the headers corresponding to the
+// components required by WithRequiredComponents, the same ones advertised to the clients by draftChallenge.
+func (k httpSigVerifier) checkDraftHeaders(params map[string]string) error {
+	signed := draftSignedHeaders(params)
+	for _, component := range k.components {
+		if h := draftHeaderName(component); h != "" && !slices.Contains(signed, h) {
+			return errors.Newf("signature doesn't cover the required %q header", h)
+		}
+	}
+	return nil
+}
+
+// checkDraftFreshness rejects the draft-cavage signatures created outside the window for which we remember
+// them in the nonce store, so they can't be replayed after being forgotten. The creation time is taken from
+// the "created" parameter, or from the Date header, and the signature must cover the one being used.
+func (k httpSigVerifier) checkDraftFreshness(r *http.Request, params map[string]string) error {
+	signed := draftSignedHeaders(params)
+
+	var created time.Time
+	switch {
+	case slices.Contains(signed, "(created)"):
+		ts, err := strconv.ParseInt(params["created"], 10, 64)
+		if err != nil {
+			return &VerificationError{Kind: ErrMalformed, Err: errors.Annotatef(err, "invalid signature created parameter")}
+		}
+		created = time.Unix(ts, 0)
+	case slices.Contains(signed, "date"):
+		d, err := http.ParseTime(r.Header.Get("Date"))
+		if err != nil {
+			return &VerificationError{Kind: ErrMalformed, Err: errors.Annotatef(err, "invalid Date header")}
+		}
+		created = d
+	default:
+		return &VerificationError{Kind: ErrMalformed, Err: errors.Newf("signature doesn't cover its creation time")}
+	}
+
+	now := k.now()
+	if created.After(now.Add(sigValidDeltaDuration)) || created.Before(now.Add(-sigMaxAgeDuration)) {
+		return &VerificationError{Kind: ErrExpired, Err: errors.Newf("signature created at %s is outside the acceptable window", created.UTC().Format(time.RFC3339))}
+	}
+	return nil
+}
+
+// draftSignedHeaders returns the lowercase names of the headers covered by a draft-cavage signature.
+func draftSignedHeaders(params map[string]string) []string {
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(headers) == 0 {
+		// NOTE(marius): the draft specifies that only the Date header is signed when the parameter is missing.
+		return []string{"date"}
+	}
+	return headers
+}
+
+// draftSignatureParams parses the parameters of a draft-cavage HTTP signature, found either in
+// the Signature header, or in the Authorization header using the "Signature" scheme.
+func draftSignatureParams(h http.Header) map[string]string {
+	sig := h.Get("Signature")
+	if sig == "" {
+		typ, auth := getAuthorization(h.Get("Authorization"))
+		if typ != "Signature" {
+			return nil
+		}
+		sig = auth
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(sig, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			continue
+		}
+		params[key] = strings.Trim(val, `"`)
+	}
+	return params
 }
 
 func (k httpSigVerifier) Verify(r *http.Request) (vocab.Actor, error) {
+	res, err := k.VerifyResult(r)
+	return res.Actor, err
+}
+
+// VerifyResult checks either RFC9421 or draft-cavage HTTP signatures, depending on the headers present in the request.
+func (k httpSigVerifier) VerifyResult(r *http.Request) (VerificationResult, error) {
 	if k.loader == nil {
-		return AnonymousActor, errInvalidStorage
+		return anonymousResult(), errInvalidStorage
 	}
 	if r == nil || r.Header == nil {
-		return AnonymousActor, nil
+		return anonymousResult(), nil
 	}
 
 	if sigInput := r.Header.Get("Signature-Input"); sigInput != "" {
-		actor, err := k.VerifyRFCSignature(r)
+		res, err := k.VerifyRFCSignatureResult(r)
 		if err != nil {
-			return AnonymousActor, err
+			return anonymousResult(), err
 		}
-		return actor, nil
+		return res, nil
 	}
 
-	actor, err := k.VerifyDraftSignature(r)
+	res, err := k.VerifyDraftSignatureResult(r)
 	if err != nil {
-		return AnonymousActor, err
+		return anonymousResult(), err
 	}
-	return actor, nil
+	return res, nil
 }
 
 func compatibleDraftVerifyAlgorithms(pubKey crypto.PublicKey) []draft.Algorithm {
-	switch k := pubKey.(type) {
-	case *rsa.PublicKey:
-		switch k.Size() {
-		case 128, 256:
-			return []draft.Algorithm{draft.RSA_SHA256}
-		case 384:
-			return []draft.Algorithm{draft.RSA_SHA384}
-		case 512:
-			return []draft.Algorithm{draft.RSA_SHA512}
-		}
-		return []draft.Algorithm{}
-	case *ecdsa.PublicKey:
-		if p := k.Params(); p != nil {
-			switch p.BitSize {
-			case 256:
-				return []draft.Algorithm{draft.ECDSA_SHA256}
-			case 384:
-				return []draft.Algorithm{draft.ECDSA_SHA384}
-			case 512:
-				return []draft.Algorithm{draft.ECDSA_SHA512}
-			}
+	var algs []draft.Algorithm
+	for _, alg := range matchingAlgorithms(pubKey) {
+		if alg.Draft != "" {
+			algs = append(algs, alg.Draft)
 		}
-		return []draft.Algorithm{}
-	case ed25519.PublicKey:
-		return []draft.Algorithm{draft.ED25519}
 	}
-	return nil
+	return algs
 }
 
 func toCryptoPublicKey(key vocab.PublicKey) (crypto.PublicKey, error) {
@@ -94,6 +94,7 @@ func Test_httpSigVerifier_Verify(t *testing.T) {
 		req         *http.Request
 		want        vocab.Actor
 		wantErr     error
+		wantKind    ErrorKind
 	}{
 		{
 			name:    "nil request",
@@ -106,7 +107,7 @@ func Test_httpSigVerifier_Verify(t *testing.T) {
 			loader:  &localRemoteLoader{st: st()},
 			req:     mockGetReq(),
 			want:    AnonymousActor,
-			wantErr: errors.Annotatef(errors.BadRequestf(`neither "Signature" nor "Authorization" have signature parameters`), "unable to initialize HTTP Signatures verifier"),
+			wantErr: errors.NewUnauthorized(errors.NotFoundf(`neither "Signature" nor "Authorization" have signature parameters`), "missing HTTP Signature"),
 		}, {
 			name:   "GET no corresponding signature",
 			loader: mockLoader{},
@@ -135,8 +136,9 @@ func Test_httpSigVerifier_Verify(t *testing.T) {
 				"Signature-Input": []string{`sig-b21=();created=1618884473;keyid="test-key-rsa-pss";nonce="b3k2pp5k7z-50gnwp.yemd"`},
 				"Signature":       []string{`sig-b21=:d2pmTvmbncD3xQm8E9ZV2828BjQWGgiwAaw5bAkgibUopemLJcWDy/lkbbHAve4cRAtx31Iq786U7it++wgGxbtRxf8Udx7zFZsckzXaJMkA7ChG52eSkFxykJeNqsrWH5S+oxNFlD4dzVuwe8DhTSja8xxbR/Z2cOGdCbzR72rgFWhzx2VjBqJzsPLMIQKhO4DGezXehhWwE56YCE+O6c0mKZsfxVrogUvA4HELjVKWmAvtl6UnCh8jYzuVG5WSb/QEVPnP5TmcAnLH1g+s++v6d4s8m0gCw1fV5/SITLq9mhho8K3+7EPYTU8IU1bLhdxO5Nyt8C8ssinQ98Xw9Q==:`},
 			}),
-			want:    AnonymousActor,
-			wantErr: errors.Annotatef(errors.Newf("unable to fetch key: test-key-rsa-pss"), "verification failed"),
+			want:     AnonymousActor,
+			wantErr:  errors.Annotatef(errors.NewNotFound(errors.Newf("URL must use http or https"), "invalid key IRI: %s", "test-key-rsa-pss"), "verification failed"),
+			wantKind: ErrUnknownKey,
 		},
 		{
 			name:        "minimal signature using rsa-sha512 example - no content-digest",
@@ -166,6 +168,18 @@ func Test_httpSigVerifier_Verify(t *testing.T) {
 		}
 		v := httpSigVerifier{loader: tt.loader, l: lw.Dev(lw.SetOutput(t.Output()))}
 		t.Run(tt.name, verifierTest(v, tt.req, tt.want, tt.wantErr))
+		if tt.wantKind == "" {
+			continue
+		}
+		t.Run(tt.name+" error kind", func(t *testing.T) {
+			_, err := v.Verify(tt.req)
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("Verify() error = %v, want %s", err, tt.wantKind)
+			}
+			if err == nil || err.Error() != tt.wantErr.Error() {
+				t.Errorf("Verify() error = %v, want %s", err, tt.wantErr)
+			}
+		})
 	}
 }
 
@@ -420,6 +434,11 @@ func Test_compatibleDraftVerifyAlgorithms(t *testing.T) {
 			pubKey: pubKeyECDSA,
 			want:   []draft.Algorithm{draft.ECDSA_SHA256},
 		},
+		{
+			name:   "ecdsa P-521",
+			pubKey: &prvKeyECDSAP521.PublicKey,
+			want:   []draft.Algorithm{draft.ECDSA_SHA512},
+		},
 		{
 			name:   "ed25519",
 			pubKey: pubKeyEd25519,
@@ -448,6 +467,7 @@ func TestLoadRemoteKey(t *testing.T) {
 		want      vocab.Actor
 		wantKey   *vocab.PublicKey
 		wantErr   error
+		wantKind  ErrorKind
 	}{
 		{
 			name:    "empty",
@@ -513,7 +533,35 @@ func TestLoadRemoteKey(t *testing.T) {
 				p := mockActorKey("http://example.com/~jdoe/key", "http://example.com/~jdoe", prv)
 				return &p
 			}(),
-			wantErr: errors.Newf("unable to fetch actor: http://example.com/~jdoe/key"),
+			wantErr: errors.NotFoundf("unable to fetch actor: http://example.com/~jdoe"),
+		},
+		{
+			name: "good key, owner on another server",
+			args: args{
+				ctx: context.Background(),
+				iri: "http://example.com/~jdoe/key",
+			},
+			handlerFn: func(w http.ResponseWriter, r *http.Request) {
+				actor := mockActor()
+				if strings.HasSuffix(r.URL.Path, "/key") {
+					actor.PublicKey.ID = "http://example.com/~jdoe/key"
+					actor.PublicKey.Owner = "http://example.org/~jdoe"
+					payload, _ := jsonld.Marshal(actor.PublicKey)
+
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write(payload)
+					return
+				}
+				t.Errorf("LoadRemoteKey() requested the owner from the server of the key: %s", r.URL)
+				w.WriteHeader(http.StatusNotFound)
+			},
+			want: AnonymousActor,
+			wantKey: func() *vocab.PublicKey {
+				p := mockActorKey("http://example.com/~jdoe/key", "http://example.org/~jdoe", prv)
+				return &p
+			}(),
+			wantErr:  errors.Newf("key http://example.com/~jdoe/key is not hosted on the server of its owner http://example.org/~jdoe"),
+			wantKind: ErrPolicy,
 		},
 		{
 			name: "good key, good actor",
@@ -571,6 +619,9 @@ func TestLoadRemoteKey(t *testing.T) {
 				t.Errorf("LoadRemoteKey() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
 				return
 			}
+			if tt.wantKind != "" && !errors.Is(err, tt.wantKind) {
+				t.Errorf("LoadRemoteKey() error = %v, want %s", err, tt.wantKind)
+			}
 			if !cmp.Equal(act, tt.want, EquateItems) {
 				t.Errorf("LoadRemoteKey() got = %s", cmp.Diff(tt.want, act, EquateItems))
 			}
@@ -649,20 +700,29 @@ func cavageMockReq(hh ...url.Values) *http.Request {
 	return mockPostReq([]byte(`{"hello": "world"}`), cavageHdrs)
 }
 
+// cavageClock returns the Date of the draft examples, so their signatures are still fresh.
+func cavageClock() time.Time {
+	return time.Date(2014, time.January, 5, 21, 31, 40, 0, time.UTC)
+}
+
+const cavageSignature = `keyId="http://example.com/~jdoe#main",algorithm="rsa-sha512",signature="SjWJWbWN7i0wzBvtPl8rbASWz5xQW6mcJmn+ibttBqtifLN7Sazz6m79cNfwwb8DMJ5cou1s7uEGKKCs+FLEEaDV5lp7q25WqS+lavg7T8hc0GppauB6hbgEKTwblDHYGEtbGmtdHgVCk9SuS13F0hZ8FD0k/5OxEPXe5WozsbM="`
+
 func Test_httpSigVerifier_VerifyDraftSignature(t *testing.T) {
 	testActor := mockActor()
 	testActor.ID = "Test"
 	testActor.PublicKey.ID = "Test"
 	type fields struct {
-		loader keyLoader
+		loader     keyLoader
+		components []string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		created time.Time
-		req     *http.Request
-		want    vocab.Actor
-		wantErr error
+		name     string
+		fields   fields
+		clock    ClockFn
+		req      *http.Request
+		want     vocab.Actor
+		wantErr  error
+		wantKind ErrorKind
 	}{
 		{
 			name:    "empty",
@@ -683,7 +743,7 @@ func Test_httpSigVerifier_VerifyDraftSignature(t *testing.T) {
 			fields:  fields{loader: mockLoader{}},
 			req:     mockGetReq(),
 			want:    AnonymousActor,
-			wantErr: errors.Annotatef(errors.Newf(`neither "Signature" nor "Authorization" have signature parameters`), "unable to initialize HTTP Signatures verifier"),
+			wantErr: errors.NewUnauthorized(errors.NotFoundf(`neither "Signature" nor "Authorization" have signature parameters`), "missing HTTP Signature"),
 		},
 		{
 			name:    "bad signature",
@@ -699,26 +759,169 @@ func Test_httpSigVerifier_VerifyDraftSignature(t *testing.T) {
 					st: st(cavageActor, mockActorKey("http://example.com/~jdoe#main", "http://example.com/~jdoe", cavagePrvKeyRSA), cavagePrvKeyRSA),
 				},
 			},
+			clock: cavageClock,
 			req: cavageMockReq(url.Values{
-				"Signature": []string{`keyId="http://example.com/~jdoe#main",algorithm="rsa-sha512",signature="SjWJWbWN7i0wzBvtPl8rbASWz5xQW6mcJmn+ibttBqtifLN7Sazz6m79cNfwwb8DMJ5cou1s7uEGKKCs+FLEEaDV5lp7q25WqS+lavg7T8hc0GppauB6hbgEKTwblDHYGEtbGmtdHgVCk9SuS13F0hZ8FD0k/5OxEPXe5WozsbM="`},
+				"Signature": []string{cavageSignature},
 				"Date":      []string{`Sun, 05 Jan 2014 21:31:40 GMT`},
 			}),
 			want: mockActor(),
 		},
+		{
+			name: "stale signature",
+			fields: fields{
+				loader: localRemoteLoader{
+					st: st(cavageActor, mockActorKey("http://example.com/~jdoe#main", "http://example.com/~jdoe", cavagePrvKeyRSA), cavagePrvKeyRSA),
+				},
+			},
+			clock: func() time.Time { return cavageClock().Add(sigMaxAgeDuration + time.Minute) },
+			req: cavageMockReq(url.Values{
+				"Signature": []string{cavageSignature},
+				"Date":      []string{`Sun, 05 Jan 2014 21:31:40 GMT`},
+			}),
+			want:    AnonymousActor,
+			wantErr: errors.Newf("signature created at 2014-01-05T21:31:40Z is outside the acceptable window"),
+		},
+		{
+			name:   "signature not covering the required headers",
+			fields: fields{loader: mockLoader{}, components: []string{"@method", "@path", "date"}},
+			clock:  cavageClock,
+			req: cavageMockReq(url.Values{
+				"Signature": []string{cavageSignature},
+				"Date":      []string{`Sun, 05 Jan 2014 21:31:40 GMT`},
+			}),
+			want:     AnonymousActor,
+			wantErr:  errors.Newf(`signature doesn't cover the required "(request-target)" header`),
+			wantKind: ErrPolicy,
+		},
+		{
+			name:   "signature not covering the date",
+			fields: fields{loader: mockLoader{}},
+			clock:  cavageClock,
+			req: cavageMockReq(url.Values{
+				"Signature": []string{`keyId="http://example.com/~jdoe#main",algorithm="rsa-sha512",headers="digest",signature="SjWJ"`},
+			}),
+			want:    AnonymousActor,
+			wantErr: errors.Newf("signature doesn't cover its creation time"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			k := httpSigVerifier{
-				loader: tt.fields.loader,
-				l:      lw.Dev(lw.SetOutput(t.Output())),
+				loader:     tt.fields.loader,
+				components: tt.fields.components,
+				clock:      tt.clock,
+				l:          lw.Dev(lw.SetOutput(t.Output())),
 			}
 			got, err := k.VerifyDraftSignature(tt.req)
 			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
 				t.Fatalf("VerifyDraftSignature() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
 			}
+			if tt.wantKind != "" && !errors.Is(err, tt.wantKind) {
+				t.Errorf("VerifyDraftSignature() error = %v, want %v", err, tt.wantKind)
+			}
 			if !cmp.Equal(got, tt.want, EquateItems) {
 				t.Errorf("VerifyDraftSignature() got = %s", cmp.Diff(tt.want, got, EquateItems))
 			}
 		})
 	}
 }
+
+func Test_httpSigVerifier_VerifyDraftSignature_replay(t *testing.T) {
+	k := httpSigVerifier{
+		loader: localRemoteLoader{
+			st: st(cavageActor, mockActorKey("http://example.com/~jdoe#main", "http://example.com/~jdoe", cavagePrvKeyRSA), cavagePrvKeyRSA),
+		},
+		ncFn:  new(syncedNonceStore),
+		clock: cavageClock,
+		l:     lw.Dev(lw.SetOutput(t.Output())),
+	}
+	buildReq := func() *http.Request {
+		return cavageMockReq(url.Values{
+			"Signature": []string{cavageSignature},
+			"Date":      []string{`Sun, 05 Jan 2014 21:31:40 GMT`},
+		})
+	}
+
+	if _, err := k.VerifyDraftSignature(buildReq()); err != nil {
+		t.Fatalf("VerifyDraftSignature() unexpected error = %s", err)
+	}
+	sig := draftSignatureParams(buildReq().Header)["signature"]
+	wantErr := errors.Annotatef(errInvalidNonce(nonceKey("http://example.com/~jdoe#main\n"+sig)), "signature replay detected for key http://example.com/~jdoe#main")
+
+	got, err := k.VerifyDraftSignature(buildReq())
+	if !cmp.Equal(err, wantErr, EquateWeakErrors) {
+		t.Errorf("VerifyDraftSignature() error = %s", cmp.Diff(wantErr, err, EquateWeakErrors))
+	}
+	if !cmp.Equal(got, AnonymousActor, EquateItems) {
+		t.Errorf("VerifyDraftSignature() got = %s", cmp.Diff(AnonymousActor, got, EquateItems))
+	}
+}
+
+func TestVerifier_VerifyResult_draftReplay(t *testing.T) {
+	a := Verifier(
+		WithStorage(st(cavageActor, mockActorKey("http://example.com/~jdoe#main", "http://example.com/~jdoe", cavagePrvKeyRSA), cavagePrvKeyRSA)),
+		WithClock(cavageClock),
+		// NOTE(marius): the key of the draft examples is too small for the default policy
+		WithKeyPolicy(KeyPolicy{}),
+		WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+	)
+	buildReq := func() *http.Request {
+		return cavageMockReq(url.Values{
+			"Signature": []string{cavageSignature},
+			"Date":      []string{`Sun, 05 Jan 2014 21:31:40 GMT`},
+		})
+	}
+
+	if _, err := a.VerifyResult(buildReq()); err != nil {
+		t.Fatalf("VerifyResult() unexpected error = %s", err)
+	}
+	got, err := a.VerifyResult(buildReq())
+	if !errors.Is(err, ErrReplayed) {
+		t.Errorf("VerifyResult() error = %v, want a replay", err)
+	}
+	if !cmp.Equal(got.Actor, AnonymousActor, EquateItems) {
+		t.Errorf("VerifyResult() got = %s", cmp.Diff(AnonymousActor, got.Actor, EquateItems))
+	}
+}
+
+func Test_draftSignatureParams(t *testing.T) {
+	tests := []struct {
+		name string
+		h    http.Header
+		want map[string]string
+	}{
+		{
+			name: "empty",
+			h:    http.Header{},
+		},
+		{
+			name: "bearer authorization",
+			h:    http.Header{"Authorization": []string{"Bearer test"}},
+		},
+		{
+			name: "signature header",
+			h:    http.Header{"Signature": []string{`keyId="Test",algorithm="rsa-sha256",headers="(request-target) host date",signature="c2lnbmF0dXJl"`}},
+			want: map[string]string{
+				"keyId":     "Test",
+				"algorithm": "rsa-sha256",
+				"headers":   "(request-target) host date",
+				"signature": "c2lnbmF0dXJl",
+			},
+		},
+		{
+			name: "authorization header",
+			h:    http.Header{"Authorization": []string{`Signature keyId="Test",signature="c2lnbmF0dXJl=="`}},
+			want: map[string]string{
+				"keyId":     "Test",
+				"signature": "c2lnbmF0dXJl==",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := draftSignatureParams(tt.h); !cmp.Equal(got, tt.want) {
+				t.Errorf("draftSignatureParams() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// KeySet provides the public keys used for verifying the signatures of JWT access tokens.
+type KeySet interface {
+	// Key returns the public key identified by kid.
+	// When kid is empty, it should return the key only if the set contains a single one.
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// jwk is a JSON Web Key, as described in RFC7517, restricted to the parameters of public keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	// D is the private exponent, or the private key, it must never be present in the documents we receive.
+	D string `json:"d,omitempty"`
+}
+
+// jwks is a JSON Web Key Set, it maps the key IDs to the public keys.
+type jwks map[string]crypto.PublicKey
+
+// ParseJWKS parses the public keys of a JSON Web Key Set document.
+// The keys that are not meant for signatures, or that we don't support, are skipped.
+func ParseJWKS(data []byte) (KeySet, error) {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Annotatef(err, "unable to parse JWKS document")
+	}
+	set := make(jwks, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid key %q", k.Kid)
+		}
+		if pub != nil {
+			set[k.Kid] = pub
+		}
+	}
+	return set, nil
+}
+
+func (s jwks) Key(kid string) (crypto.PublicKey, error) {
+	if pub, ok := s[kid]; ok {
+		return pub, nil
+	}
+	if kid == "" && len(s) == 1 {
+		for _, pub := range s {
+			return pub, nil
+		}
+	}
+	return nil, errors.NotFoundf("key %q not found", kid)
+}
+
+// publicKey returns the public key corresponding to the JWK, or nil for the unsupported key types.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	if k.D != "" {
+		return nil, errors.Newf("the key contains private key material")
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid exponent")
+		}
+		exp := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.Newf("invalid RSA key parameters")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Newf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid y coordinate")
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.Newf("invalid %s coordinates length", k.Crv)
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.Newf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.Newf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// jwksFile is a KeySet loaded from a local JWKS document, which gets reloaded when the file changes.
+type jwksFile struct {
+	path string
+
+	m       sync.Mutex
+	modTime time.Time
+	keys    KeySet
+}
+
+// JWKSFile returns a KeySet that loads the keys from the JWKS document at path.
+// The document is read again when its modification time changes, so keys can be rotated by
+// adding the new key to the document, and removing the old one when all its tokens expired.
+func JWKSFile(path string) KeySet {
+	return &jwksFile{path: path}
+}
+
+func (f *jwksFile) Key(kid string) (crypto.PublicKey, error) {
+	keys, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return keys.Key(kid)
+}
+
+func (f *jwksFile) load() (KeySet, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load JWKS document %s", f.path)
+	}
+	if f.keys != nil && fi.ModTime().Equal(f.modTime) {
+		return f.keys, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load JWKS document %s", f.path)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	f.keys, f.modTime = keys, fi.ModTime()
+	return keys, nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func mockJWK(kid string, pub crypto.PublicKey) jwk {
+	enc := base64.RawURLEncoding.EncodeToString
+	k := jwk{Kid: kid, Use: "sig"}
+	switch pk := pub.(type) {
+	case *rsa.PublicKey:
+		k.Kty, k.N, k.E = "RSA", enc(pk.N.Bytes()), enc(big.NewInt(int64(pk.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		raw, _ := pk.Bytes()
+		k.Kty, k.Crv, k.X, k.Y = "EC", pk.Curve.Params().Name, enc(raw[1:1+size]), enc(raw[1+size:])
+	case ed25519.PublicKey:
+		k.Kty, k.Crv, k.X = "OKP", "Ed25519", enc(pk)
+	}
+	return k
+}
+
+func mockJWKS(keys ...jwk) []byte {
+	data, _ := json.Marshal(map[string][]jwk{"keys": keys})
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    KeySet
+		wantErr error
+	}{
+		{
+			name:    "empty",
+			wantErr: errors.Annotatef(errors.Newf("unexpected end of JSON input"), "unable to parse JWKS document"),
+		},
+		{
+			name: "no keys",
+			data: []byte(`{"keys":[]}`),
+			want: jwks{},
+		},
+		{
+			name: "RSA, ECDSA and Ed25519 keys",
+			data: mockJWKS(
+				mockJWK("rsa", prvKeyRSA.Public()),
+				mockJWK("ecdsa", prvKeyECDSA.Public()),
+				mockJWK("ed25519", prvKeyEd25519.Public()),
+			),
+			want: jwks{
+				"rsa":     prvKeyRSA.Public(),
+				"ecdsa":   prvKeyECDSA.Public(),
+				"ed25519": prvKeyEd25519.Public(),
+			},
+		},
+		{
+			name: "skip encryption and unsupported keys",
+			data: mockJWKS(
+				jwk{Kid: "enc", Use: "enc", Kty: "RSA"},
+				jwk{Kid: "oct", Kty: "oct"},
+				mockJWK("ed25519", prvKeyEd25519.Public()),
+			),
+			want: jwks{"ed25519": prvKeyEd25519.Public()},
+		},
+		{
+			name:    "invalid EC key",
+			data:    mockJWKS(jwk{Kid: "ec", Kty: "EC", Crv: "P-256", X: "AA", Y: "AA"}),
+			wantErr: errors.Annotatef(errors.Newf("invalid P-256 coordinates length"), `invalid key "ec"`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseJWKS(tt.data)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("ParseJWKS() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("ParseJWKS() got = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func Test_jwks_Key(t *testing.T) {
+	single := jwks{"single": prvKeyEd25519.Public()}
+	multiple := jwks{"rsa": prvKeyRSA.Public(), "ed25519": prvKeyEd25519.Public()}
+
+	tests := []struct {
+		name    string
+		set     jwks
+		kid     string
+		want    crypto.PublicKey
+		wantErr error
+	}{
+		{
+			name: "by kid",
+			set:  multiple,
+			kid:  "rsa",
+			want: prvKeyRSA.Public(),
+		},
+		{
+			name: "no kid, single key",
+			set:  single,
+			want: prvKeyEd25519.Public(),
+		},
+		{
+			name:    "no kid, multiple keys",
+			set:     multiple,
+			wantErr: errors.NotFoundf(`key "" not found`),
+		},
+		{
+			name:    "unknown kid",
+			set:     single,
+			kid:     "unknown",
+			wantErr: errors.NotFoundf(`key "unknown" not found`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.set.Key(tt.kid)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("Key() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Key() got = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestJWKSFile_rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	write := func(data []byte, modTime time.Time) {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("unable to write JWKS document: %s", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("unable to change JWKS document times: %s", err)
+		}
+	}
+
+	ks := JWKSFile(path)
+	if _, err := ks.Key("old"); err == nil {
+		t.Errorf("Key() for a missing document expected error")
+	}
+
+	now := time.Now()
+	write(mockJWKS(mockJWK("old", prvKeyRSA.Public())), now.Add(-time.Hour))
+	if _, err := ks.Key("old"); err != nil {
+		t.Errorf("Key() error = %v", err)
+	}
+
+	write(mockJWKS(mockJWK("new", prvKeyEd25519.Public())), now)
+	if _, err := ks.Key("old"); !errors.IsNotFound(err) {
+		t.Errorf("Key() for a rotated key error = %v", err)
+	}
+	got, err := ks.Key("new")
+	if err != nil {
+		t.Errorf("Key() error = %v", err)
+	}
+	if !cmp.Equal(got, prvKeyEd25519.Public()) {
+		t.Errorf("Key() got = %s", cmp.Diff(prvKeyEd25519.Public(), got))
+	}
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+// WithJWTAccessTokens enables verifying the access tokens that are RFC9068 JWTs offline, using the keys
+// in the ks set, without loading the token or the actor from the storage.
+// The tokens need to be issued by issuer, and if audience is not empty, to be meant for it.
+// The opaque access tokens are still verified using the storage.
+//
+// As the JWTs are not loaded from the storage, they can't be revoked before they expire, so they should be
+// short-lived, and the RevocationHandler rejects them with unsupported_token_type. The actor of the
+// VerificationResult is also not loaded, it contains only the IRI from the "sub" claim.
+func WithJWTAccessTokens(ks KeySet, issuer, audience string) InitFn {
+	return func(c *config) {
+		c.jwt = &jwtVerifier{keys: ks, issuer: issuer, audience: audience}
+	}
+}
+
+type jwtVerifier struct {
+	keys     KeySet
+	issuer   string
+	audience string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtClaims are the claims of RFC9068 access tokens, the subject is the IRI of the actor.
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ClientID  string      `json:"client_id"`
+	Scope     string      `json:"scope,omitempty"`
+	ExpiresAt int64       `json:"exp"`
+	IssuedAt  int64       `json:"iat"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	// Confirmation holds the keys the token is bound to.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// jwtAudience is the "aud" claim, which can be either a string or an array of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// isJWT checks if tok has the structure of a compact JWS, the opaque tokens generated by osin don't contain dots.
+func isJWT(tok string) bool {
+	return strings.Count(tok, ".") == 2
+}
+
+// errInvalidToken returns an Unauthorized error with the RFC6750 invalid_token challenge, classified as kind.
+func errInvalidToken(kind ErrorKind, err error) error {
+	return classify(kind, "", "", errors.NewUnauthorized(err, "invalid access token").Challenge(`Bearer error="invalid_token"`))
+}
+
+// verify checks the signature and claims of the tok JWT, and returns the corresponding access data.
+// The now time is used only for checking the "nbf" claim, the expiry is checked together with the opaque tokens.
+func (j jwtVerifier) verify(tok string, now time.Time) (accessToken, error) {
+	parts := strings.Split(tok, ".")
+	header := jwtHeader{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return accessToken{}, errInvalidToken(ErrMalformed, errors.Annotatef(err, "invalid JWT header"))
+	}
+	if typ := strings.ToLower(header.Typ); typ != "at+jwt" && typ != "application/at+jwt" {
+		return accessToken{}, errInvalidToken(ErrMalformed, errors.Newf("invalid JWT type %q", header.Typ))
+	}
+	claims := jwtClaims{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return accessToken{}, errInvalidToken(ErrMalformed, errors.Annotatef(err, "invalid JWT claims"))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return accessToken{}, errInvalidToken(ErrMalformed, errors.Annotatef(err, "invalid JWT signature encoding"))
+	}
+
+	pub, err := j.keys.Key(header.Kid)
+	if err != nil {
+		return accessToken{}, errInvalidToken(keyLoadKind(err), err)
+	}
+	if err = verifyJWS(header.Alg, pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return accessToken{}, errInvalidToken(ErrBadSignature, err)
+	}
+
+	if claims.Issuer != j.issuer {
+		return accessToken{}, errInvalidToken(ErrUnknownKey, errors.Newf("token issued by %q", claims.Issuer))
+	}
+	if j.audience != "" && !slices.Contains(claims.Audience, j.audience) {
+		return accessToken{}, errInvalidToken(ErrUnknownKey, errors.Newf("token not meant for %q", j.audience))
+	}
+	if claims.Subject == "" || claims.ExpiresAt == 0 {
+		return accessToken{}, errInvalidToken(ErrMalformed, errors.Newf("missing required JWT claims"))
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0)) {
+		return accessToken{}, errInvalidToken(ErrExpired, errors.Newf("token is not valid yet"))
+	}
+
+	created := claims.IssuedAt
+	if created == 0 {
+		created = claims.ExpiresAt
+	}
+	// NOTE(marius): osin keeps the lifetime of the tokens as an int32, so we reject the ones that don't fit.
+	if claims.ExpiresAt < created || uint64(claims.ExpiresAt-created) > math.MaxInt32 {
+		return accessToken{}, errInvalidToken(ErrMalformed, errors.Newf("invalid JWT lifetime"))
+	}
+	at := accessToken{
+		AccessData: &osin.AccessData{
+			Client:      &osin.DefaultClient{Id: claims.ClientID},
+			AccessToken: tok,
+			ExpiresIn:   int32(claims.ExpiresAt - created),
+			Scope:       claims.Scope,
+			CreatedAt:   time.Unix(created, 0),
+			UserData:    vocab.IRI(claims.Subject),
+		},
+		offline: true,
+	}
+	if claims.Confirmation != nil {
+		at.cnf = *claims.Confirmation
+	}
+	return at, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWS verifies the signature of a JWS using the alg algorithm, as described in RFC7518.
+// NOTE(marius): the "none" and HMAC algorithms are not supported, as the keys need to be public.
+func verifyJWS(alg string, pub crypto.PublicKey, signed, sig []byte) error {
+	h := crypto.SHA256
+	switch {
+	case strings.HasSuffix(alg, "384"):
+		h = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		h = crypto.SHA512
+	}
+
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		pk, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.Newf("key of type %T can't verify %s signatures", pub, alg)
+		}
+		digest := jwsDigest(h, signed)
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pk, h, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pk, h, digest, sig)
+	case "ES256", "ES384", "ES512":
+		pk, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.Newf("key of type %T can't verify %s signatures", pub, alg)
+		}
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		if want := map[string]int{"ES256": 32, "ES384": 48, "ES512": 66}[alg]; size != want || len(sig) != 2*size {
+			return errors.Newf("invalid %s signature", alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pk, jwsDigest(h, signed), r, s) {
+			return errors.Newf("invalid %s signature", alg)
+		}
+		return nil
+	case "EdDSA", "Ed25519":
+		pk, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errors.Newf("key of type %T can't verify %s signatures", pub, alg)
+		}
+		if !ed25519.Verify(pk, signed, sig) {
+			return errors.Newf("invalid %s signature", alg)
+		}
+		return nil
+	}
+	return errors.Newf("unsupported JWS algorithm %q", alg)
+}
+
+func jwsDigest(h crypto.Hash, data []byte) []byte {
+	switch h {
+	case crypto.SHA384:
+		d := sha512.Sum384(data)
+		return d[:]
+	case crypto.SHA512:
+		d := sha512.Sum512(data)
+		return d[:]
+	}
+	d := sha256.Sum256(data)
+	return d[:]
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+// signJWT builds a compact JWS of the claims, signed with the key using the alg algorithm.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims any) string {
+	return signJWS(t, alg, key, jwtHeader{Alg: alg, Kid: kid, Typ: "at+jwt"}, claims)
+}
+
+// signJWS builds a compact JWS of the header and claims, signed with the key using the alg algorithm.
+func signJWS(t *testing.T, alg string, key crypto.Signer, header, claims any) string {
+	enc := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("unable to encode JWS: %s", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(header) + "." + enc(claims)
+
+	var sig []byte
+	var err error
+	switch pk := key.(type) {
+	case *rsa.PrivateKey:
+		h := crypto.SHA256
+		if alg == "PS256" {
+			sig, err = rsa.SignPSS(rand.Reader, pk, h, jwsDigest(h, []byte(signed)), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, pk, h, jwsDigest(h, []byte(signed)))
+		}
+	case *ecdsa.PrivateKey:
+		r, s, e := ecdsa.Sign(rand.Reader, pk, jwsDigest(crypto.SHA256, []byte(signed)))
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), e
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(pk, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("unable to sign JWS: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// tamperJWT replaces the claims of the tok JWT, keeping its original signature.
+func tamperJWT(tok string, claims jwtClaims) string {
+	parts := strings.Split(tok, ".")
+	data, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(data) + "." + parts[2]
+}
+
+func mockClaims(now time.Time) jwtClaims {
+	return jwtClaims{
+		Issuer:    "https://example.com",
+		Subject:   "http://example.com/~jdoe",
+		Audience:  jwtAudience{"https://media.example.com"},
+		ClientID:  "test-client",
+		Scope:     "read write",
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	}
+}
+
+func Test_isJWT(t *testing.T) {
+	tests := map[string]bool{
+		"":                                false,
+		"ZjU5NjI3MDQtOTU4Zi00YTJkLWFlMmE": false,
+		"a.b":                             false,
+		"a.b.c":                           true,
+		"a.b.c.d.e":                       false,
+	}
+	for tok, want := range tests {
+		if got := isJWT(tok); got != want {
+			t.Errorf("isJWT(%q) = %t, want %t", tok, got, want)
+		}
+	}
+}
+
+func TestOAuth2_VerifyAccessCodeResult_JWT(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	keys := jwks{
+		"rsa":     prvKeyRSA.Public(),
+		"ecdsa":   prvKeyECDSA.Public(),
+		"ed25519": prvKeyEd25519.Public(),
+	}
+	valid := VerificationResult{
+		Method:   MethodOAuth2,
+		Actor:    vocab.Actor{ID: "http://example.com/~jdoe"},
+		Scopes:   []string{"read", "write"},
+		ClientID: "test-client",
+	}
+	withClaims := func(fn func(c *jwtClaims)) jwtClaims {
+		c := mockClaims(now)
+		fn(&c)
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		tok      string
+		want     VerificationResult
+		wantKind ErrorKind
+	}{
+		{
+			name: "RS256",
+			tok:  signJWT(t, "RS256", "rsa", prvKeyRSA, mockClaims(now)),
+			want: valid,
+		},
+		{
+			name: "PS256",
+			tok:  signJWT(t, "PS256", "rsa", prvKeyRSA, mockClaims(now)),
+			want: valid,
+		},
+		{
+			name: "ES256",
+			tok:  signJWT(t, "ES256", "ecdsa", prvKeyECDSA, mockClaims(now)),
+			want: valid,
+		},
+		{
+			name: "EdDSA",
+			tok:  signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, mockClaims(now)),
+			want: valid,
+		},
+		{
+			name: "audience list",
+			tok: signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) {
+				c.Audience = jwtAudience{"https://example.com", "https://media.example.com"}
+			})),
+			want: valid,
+		},
+		{
+			name:     "key mismatch",
+			tok:      signJWT(t, "EdDSA", "rsa", prvKeyEd25519, mockClaims(now)),
+			want:     anonymousResult(),
+			wantKind: ErrBadSignature,
+		},
+		{
+			name:     "unknown key",
+			tok:      signJWT(t, "EdDSA", "unknown", prvKeyEd25519, mockClaims(now)),
+			want:     anonymousResult(),
+			wantKind: ErrUnknownKey,
+		},
+		{
+			name:     "tampered claims",
+			tok:      tamperJWT(signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, mockClaims(now)), withClaims(func(c *jwtClaims) { c.Scope = "admin" })),
+			want:     anonymousResult(),
+			wantKind: ErrBadSignature,
+		},
+		{
+			name:     "other issuer",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.Issuer = "https://evil.example.com" })),
+			want:     anonymousResult(),
+			wantKind: ErrUnknownKey,
+		},
+		{
+			name:     "other audience",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.Audience = jwtAudience{"https://example.org"} })),
+			want:     anonymousResult(),
+			wantKind: ErrUnknownKey,
+		},
+		{
+			name:     "expired",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.ExpiresAt = now.Add(-time.Minute).Unix() })),
+			want:     anonymousResult(),
+			wantKind: ErrExpired,
+		},
+		{
+			name:     "not valid yet",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.NotBefore = now.Add(time.Minute).Unix() })),
+			want:     anonymousResult(),
+			wantKind: ErrExpired,
+		},
+		{
+			name:     "missing subject",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.Subject = "" })),
+			want:     anonymousResult(),
+			wantKind: ErrMalformed,
+		},
+		{
+			name:     "issued after expiry",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.IssuedAt = c.ExpiresAt + 1 })),
+			want:     anonymousResult(),
+			wantKind: ErrMalformed,
+		},
+		{
+			name: "lifetime overflowing int32",
+			tok: signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) {
+				c.IssuedAt = now.Add(-time.Hour).Unix()
+				c.ExpiresAt = c.IssuedAt + math.MaxInt32 + 1
+			})),
+			want:     anonymousResult(),
+			wantKind: ErrMalformed,
+		},
+		{
+			name: "lifetime overflowing int64",
+			tok: signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) {
+				c.IssuedAt = math.MinInt64
+				c.ExpiresAt = math.MaxInt64
+			})),
+			want:     anonymousResult(),
+			wantKind: ErrMalformed,
+		},
+		{
+			name:     "bound to a DPoP key",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.Confirmation = &Confirmation{JKT: "jkt"} })),
+			want:     anonymousResult(),
+			wantKind: ErrBadSignature,
+		},
+		{
+			name:     "bound to a client certificate",
+			tok:      signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, withClaims(func(c *jwtClaims) { c.Confirmation = &Confirmation{X5T: "x5t"} })),
+			want:     anonymousResult(),
+			wantKind: ErrBadSignature,
+		},
+		{
+			name:     "opaque token falls back to storage",
+			tok:      "test",
+			want:     anonymousResult(),
+			wantKind: ErrMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := OAuth2(
+				WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+				WithJWTAccessTokens(keys, "https://example.com", "https://media.example.com"),
+				WithClock(func() time.Time { return now }),
+			)
+
+			got, err := s.VerifyAccessCodeResult(tt.tok)
+			if tt.wantKind == "" && err != nil {
+				t.Errorf("VerifyAccessCodeResult() unexpected error = %v", err)
+			}
+			if tt.wantKind != "" && !errors.Is(err, tt.wantKind) {
+				t.Errorf("VerifyAccessCodeResult() error = %v, want %v", err, tt.wantKind)
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyAccessCodeResult() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyAccessCodeResult_JWTWithStorage(t *testing.T) {
+	actor := mockActor()
+	s := OAuth2(
+		WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+		WithStorage(st(&actor, mockAccess("test", defaultClient))),
+		WithJWTAccessTokens(jwks{"ed25519": prvKeyEd25519.Public()}, "https://example.com", ""),
+	)
+
+	got, err := s.VerifyAccessCodeResult("test")
+	if err != nil {
+		t.Fatalf("VerifyAccessCodeResult() for opaque token error = %v", err)
+	}
+	if !cmp.Equal(got.Actor, actor, EquateItems) {
+		t.Errorf("VerifyAccessCodeResult() for opaque token got = %s", cmp.Diff(actor, got.Actor, EquateItems))
+	}
+
+	got, err = s.VerifyAccessCodeResult(signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, mockClaims(time.Now())))
+	if err != nil {
+		t.Fatalf("VerifyAccessCodeResult() for JWT error = %v", err)
+	}
+	want := vocab.Actor{ID: "http://example.com/~jdoe"}
+	if !cmp.Equal(got.Actor, want, EquateItems) {
+		t.Errorf("VerifyAccessCodeResult() for JWT got = %s", cmp.Diff(want, got.Actor, EquateItems))
+	}
+}
@@ -3,78 +3,62 @@ package auth
 import (
 	"context"
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/ed25519"
-	"crypto/rsa"
-	"io"
 	"net/http"
+	"strings"
 
 	"github.com/dadrus/httpsig"
 	vocab "github.com/go-ap/activitypub"
-	"github.com/go-ap/client"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/jsonld"
 )
 
 // LoadRemoteKey fetches a remote Public Key and returns it's owner.
-func LoadRemoteKey(_ context.Context, c ActivityPubClient, iri vocab.IRI) (vocab.Actor, *vocab.PublicKey, error) {
-	return (&localRemoteLoader{c: c}).loadRemoteKey(iri)
+// The host of the iri is not restricted, the HTTP client of c can use PublicTransport for that.
+func LoadRemoteKey(ctx context.Context, c ActivityPubClient, iri vocab.IRI) (vocab.Actor, *vocab.PublicKey, error) {
+	return (&localRemoteLoader{c: c}).loadRemoteKey(ctx, iri)
 }
 
 type keyLoader interface {
-	loadKey(string) (vocab.Actor, *vocab.PublicKey, error)
+	loadKey(context.Context, string) (vocab.Actor, *vocab.PublicKey, error)
 }
 
 type localRemoteLoader struct {
 	actor vocab.Actor
 	c     ActivityPubClient
 	st    readStore
+	fetch RemoteFetchPolicy
 }
 
-var errEmptyIRI = errors.Newf("empty IRI")
+var errEmptyIRI = &VerificationError{Kind: ErrUnknownKey, Err: errors.Newf("empty IRI")}
 
-func (k localRemoteLoader) loadRemoteKey(iri vocab.IRI) (vocab.Actor, *vocab.PublicKey, error) {
+func (k localRemoteLoader) loadRemoteKey(ctx context.Context, iri vocab.IRI) (vocab.Actor, *vocab.PublicKey, error) {
 	if k.c == nil {
 		return AnonymousActor, nil, errInvalidClient
 	}
 	if iri == "" {
 		return AnonymousActor, nil, errEmptyIRI
 	}
-
-	req, err := client.FetchRequest(context.Background(), string(iri), http.MethodGet)
-	if err != nil {
-		return AnonymousActor, nil, errors.Annotatef(err, "unable to create request: %s", iri)
+	u, err := iri.URL()
+	if err == nil {
+		err = checkRemoteURL(u)
 	}
-
-	resp, err := k.c.Do(req)
 	if err != nil {
-		return AnonymousActor, nil, errors.Annotatef(err, "unable to fetch key: %s", iri)
+		// NOTE(marius): a key ID that we can't fetch doesn't identify a key that we can know about.
+		return AnonymousActor, nil, errors.NewNotFound(err, "invalid key IRI: %s", iri)
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
-	var body []byte
-	if body, err = io.ReadAll(resp.Body); err != nil {
+	status, body, err := fetchRemote(ctx, k.c, string(iri), "", maxRemoteDocumentSize, k.fetch.keyCheck())
+	if err != nil {
 		return AnonymousActor, nil, err
 	}
 
-	switch resp.StatusCode {
+	switch status {
 	case http.StatusGone:
 		return AnonymousActor, nil, errors.Gonef("key does not exist: %s", iri)
 	case http.StatusOK, http.StatusNotModified:
 		// OK
 	default:
-		if errb, _ := errors.UnmarshalJSON(body); len(errb) > 0 {
-			err = errors.AnnotateFromStatus(errors.Join(errb...), resp.StatusCode, "unable to fetch key: %s", iri)
-		} else {
-			if len(body) > 0 {
-				err = errors.AnnotateFromStatus(errors.Newf("%s", body[:min(512, len(body))]), resp.StatusCode, "unable to fetch key: %s", iri)
-			} else {
-				err = errors.NewFromStatus(resp.StatusCode, "unable to fetch key: %s", iri)
-			}
-		}
-		return AnonymousActor, nil, err
+		return AnonymousActor, nil, fetchStatusError(status, body, "unable to fetch key: %s", iri)
 	}
 
 	key := new(vocab.PublicKey)
@@ -103,20 +87,63 @@ func (k localRemoteLoader) loadRemoteKey(iri vocab.IRI) (vocab.Actor, *vocab.Pub
 		// NOTE(marius): the SWICG document linked at the LoadActorFromIRIKey method mentions
 		// that we can use both key.Owner or key.Controller, however we don't have Controller
 		// in the PublicKey struct. We should probably change that.
-		it, err := k.c.LoadIRI(key.Owner)
-		if err != nil {
-			return AnonymousActor, key, errors.NewFromStatus(resp.StatusCode, "unable to fetch actor: %s", iri)
+		if act, err = k.loadRemoteOwner(ctx, key); err != nil {
+			return AnonymousActor, key, err
 		}
-
-		_ = vocab.OnActor(it, func(actor *vocab.Actor) error {
-			act = *actor
-			return nil
-		})
 	}
 
 	return act, key, nil
 }
 
+// loadRemoteOwner fetches the actor owning the key, which must be hosted on the same server as the key,
+// as otherwise any server could claim the actors of other servers as the owners of its keys.
+func (k localRemoteLoader) loadRemoteOwner(ctx context.Context, key *vocab.PublicKey) (vocab.Actor, error) {
+	keyURL, err := key.ID.URL()
+	if err != nil {
+		return AnonymousActor, errors.Annotatef(err, "invalid key IRI: %s", key.ID)
+	}
+	ownerURL, err := key.Owner.URL()
+	if err != nil {
+		return AnonymousActor, errors.NewNotFound(err, "invalid actor IRI: %s", key.Owner)
+	}
+	if !strings.EqualFold(keyURL.Host, ownerURL.Host) {
+		err = errors.Newf("key %s is not hosted on the server of its owner %s", key.ID, key.Owner)
+		return AnonymousActor, classify(ErrPolicy, string(key.ID), key.Owner, err)
+	}
+
+	status, body, err := fetchRemote(ctx, k.c, string(key.Owner), "", maxRemoteDocumentSize, k.fetch.keyCheck())
+	if err != nil {
+		return AnonymousActor, errors.Annotatef(err, "unable to fetch actor: %s", key.Owner)
+	}
+	switch status {
+	case http.StatusOK, http.StatusNotModified:
+		// OK
+	default:
+		return AnonymousActor, fetchStatusError(status, body, "unable to fetch actor: %s", key.Owner)
+	}
+
+	act := vocab.Actor{}
+	if err = jsonld.Unmarshal(body, &act); err != nil {
+		return AnonymousActor, errors.Annotatef(err, "unable to decode actor: %s", key.Owner)
+	}
+	if !act.ID.Equal(key.Owner) {
+		return AnonymousActor, errors.Newf("unable to decode actor: %s", key.Owner)
+	}
+	return act, nil
+}
+
+// fetchStatusError returns the error for an unsuccessful response to a remote fetch, including the
+// errors from its body, when it has any.
+func fetchStatusError(status int, body []byte, format string, iri vocab.IRI) error {
+	if errb, _ := errors.UnmarshalJSON(body); len(errb) > 0 {
+		return errors.AnnotateFromStatus(errors.Join(errb...), status, format, iri)
+	}
+	if len(body) > 0 {
+		return errors.AnnotateFromStatus(errors.Newf("%s", body[:min(512, len(body))]), status, format, iri)
+	}
+	return errors.NewFromStatus(status, format, iri)
+}
+
 func (k localRemoteLoader) loadLocalKey(iri vocab.IRI) (vocab.Actor, *vocab.PublicKey, error) {
 	if k.st == nil {
 		return AnonymousActor, nil, errInvalidStorage
@@ -152,7 +179,7 @@ func (k localRemoteLoader) loadLocalKey(iri vocab.IRI) (vocab.Actor, *vocab.Publ
 	return act, key, err
 }
 
-func (k localRemoteLoader) loadKey(keyID string) (vocab.Actor, *vocab.PublicKey, error) {
+func (k localRemoteLoader) loadKey(ctx context.Context, keyID string) (vocab.Actor, *vocab.PublicKey, error) {
 	// NOTE(marius): we first try to verify with the copy of the key stored locally if it exists.
 	actor, key, _ := k.loadLocalKey(vocab.IRI(keyID))
 	if key != nil {
@@ -160,12 +187,12 @@ func (k localRemoteLoader) loadKey(keyID string) (vocab.Actor, *vocab.PublicKey,
 	}
 
 	// NOTE(marius): if local verification fails, we try to fetch a fresh copy of the key and try again.
-	return k.loadRemoteKey(vocab.IRI(keyID))
+	return k.loadRemoteKey(ctx, vocab.IRI(keyID))
 }
 
-func (k *localRemoteLoader) ResolveKey(_ context.Context, id string) (httpsig.Key, error) {
+func (k *localRemoteLoader) ResolveKey(ctx context.Context, id string) (httpsig.Key, error) {
 	key := httpsig.Key{KeyID: id}
-	act, pub, err := k.loadKey(id)
+	act, pub, err := k.loadKey(ctx, id)
 	if err != nil {
 		return key, err
 	}
@@ -180,36 +207,12 @@ func rfcAlgorithmFromPublicKey(pub *vocab.PublicKey) (httpsig.SignatureAlgorithm
 		return "", nil, err
 	}
 
-	var key crypto.PublicKey
-	var alg httpsig.SignatureAlgorithm
-	switch pk := pkey.(type) {
-	case *rsa.PublicKey:
-		switch pk.Size() {
-		case 128, 256:
-			alg = httpsig.RsaPkcs1v15Sha256
-		case 384:
-			alg = httpsig.RsaPkcs1v15Sha384
-		case 512:
-			alg = httpsig.RsaPkcs1v15Sha512
-		}
-		key = pk
-	case *ecdsa.PublicKey:
-		if p := pk.Params(); p != nil {
-			switch p.BitSize {
-			case 128, 256:
-				alg = httpsig.EcdsaP256Sha256
-			case 384:
-				alg = httpsig.EcdsaP384Sha384
-			case 512:
-				alg = httpsig.EcdsaP521Sha512
-			}
+	for _, alg := range matchingAlgorithms(pkey) {
+		if alg.RFC != "" && !alg.Explicit {
+			return alg.RFC, pkey, nil
 		}
-		key = pk
-	case ed25519.PublicKey:
-		alg = httpsig.Ed25519
-		key = pk
 	}
-	return alg, key, nil
+	return "", nil, errUnsupportedKey(pkey)
 }
 
 func (k *localRemoteLoader) Actor() vocab.Actor {
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto"
 	"net/http"
 	"net/http/httptest"
@@ -63,7 +64,7 @@ func Test_keyLoader_loadKey(t *testing.T) {
 				st: tt.storage,
 			}
 
-			act, key, err := k.loadKey(tt.arg)
+			act, key, err := k.loadKey(context.Background(), tt.arg)
 			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
 				t.Fatalf("Load() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
 			}
@@ -318,7 +319,7 @@ func Test_keyLoader_loadRemoteKey(t *testing.T) {
 				st: tt.storage,
 			}
 
-			act, key, err := k.loadRemoteKey(tt.arg)
+			act, key, err := k.loadRemoteKey(context.Background(), tt.arg)
 			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
 				t.Fatalf("Load() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
 			}
@@ -440,6 +441,17 @@ func Test_rfcAlgorithmFromPublicKey(t *testing.T) {
 			want:    httpsig.Ed25519,
 			wantKey: pubKeyEd25519,
 		},
+		{
+			name:    "ecdsa521",
+			pub:     mockActorGenKey("test", "test", prvKeyECDSAP521),
+			want:    httpsig.EcdsaP521Sha512,
+			wantKey: &prvKeyECDSAP521.PublicKey,
+		},
+		{
+			name:    "unsupported ecdsa curve",
+			pub:     mockActorGenKey("test", "test", prvKeyECDSAP224),
+			wantErr: errors.Newf("unsupported ECDSA key curve"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"slices"
+	"strings"
+
+	"github.com/go-ap/errors"
+	draft "github.com/go-fed/httpsig"
+)
+
+// KeyPolicy describes which public keys and signature algorithms are accepted when verifying HTTP signatures.
+// The zero value accepts any key for which we can determine a signature algorithm.
+type KeyPolicy struct {
+	// MinRSABits is the minimum size, in bits, of the modulus of RSA keys.
+	MinRSABits int
+	// MinSecretBytes is the minimum length of the secrets used for HMAC signatures.
+	MinSecretBytes int
+	// Curves contains the names of the elliptic curves accepted for ECDSA keys, eg: "P-256".
+	// When empty all curves are accepted.
+	Curves []string
+	// Algorithms contains the names of the accepted signature algorithms, using either the
+	// RFC9421 names, eg: "rsa-pss-sha512", or the draft-cavage ones, eg: "rsa-sha256".
+	// When empty all algorithms are accepted.
+	Algorithms []string
+	// RSAPSSFallback enables verifying RFC9421 signatures made with RSA keys using the RSA-PSS algorithms
+	// when the signature doesn't specify an algorithm, and its padding is not the PKCS #1 v1.5 one.
+	RSAPSSFallback bool
+}
+
+// DefaultKeyPolicy is the policy used by the verifiers, unless a different one is set using WithKeyPolicy.
+var DefaultKeyPolicy = KeyPolicy{
+	MinRSABits:     2048,
+	MinSecretBytes: 32,
+	Curves:         []string{"P-256", "P-384", "P-521"},
+	RSAPSSFallback: true,
+}
+
+// WithKeyPolicy sets the policy that public keys and algorithms of HTTP signatures need to satisfy.
+func WithKeyPolicy(p KeyPolicy) InitFn {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// checkKey returns an error if the public key is weak or of a type we can't verify signatures with.
+func (p KeyPolicy) checkKey(pub crypto.PublicKey) error {
+	switch pk := pub.(type) {
+	case *rsa.PublicKey:
+		if bits := pk.N.BitLen(); bits < p.MinRSABits {
+			return errors.Newf("RSA key of %d bits is smaller than the minimum of %d bits", bits, p.MinRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if pk.Curve == nil {
+			return errors.Newf("ECDSA key without a curve")
+		}
+		if name := pk.Curve.Params().Name; len(p.Curves) > 0 && !slices.Contains(p.Curves, name) {
+			return errors.Newf("ECDSA curve %s is not allowed", name)
+		}
+	case ed25519.PublicKey:
+		// NOTE(marius): ed25519 keys have a fixed size, there's nothing to check.
+	case []byte:
+		if len(pk) == 0 {
+			return errors.Newf("empty shared secret")
+		}
+		if len(pk) < p.MinSecretBytes {
+			return errors.Newf("shared secret of %d bytes is shorter than the minimum of %d bytes", len(pk), p.MinSecretBytes)
+		}
+	default:
+		return errors.Newf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// checkAlgorithm returns an error if the signature algorithm is not known or is not allowed by the policy.
+func (p KeyPolicy) checkAlgorithm(alg string) error {
+	if alg == "" {
+		return errors.Newf("unable to determine signature algorithm")
+	}
+	if len(p.Algorithms) == 0 {
+		return nil
+	}
+	if !slices.ContainsFunc(p.Algorithms, func(a string) bool { return strings.EqualFold(a, alg) }) {
+		return errors.Newf("signature algorithm %s is not allowed", alg)
+	}
+	return nil
+}
+
+// draftAlgorithms returns the draft-cavage algorithms that are allowed by the policy.
+func (p KeyPolicy) draftAlgorithms(algs []draft.Algorithm) []draft.Algorithm {
+	allowed := make([]draft.Algorithm, 0, len(algs))
+	for _, alg := range algs {
+		if p.checkAlgorithm(string(alg)) == nil {
+			allowed = append(allowed, alg)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/go-ap/errors"
+	draft "github.com/go-fed/httpsig"
+	"github.com/google/go-cmp/cmp"
+)
+
+var prvKeyECDSAP224, _ = ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+
+func TestKeyPolicy_checkKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  KeyPolicy
+		pub     crypto.PublicKey
+		wantErr error
+	}{
+		{
+			name:    "nil key",
+			wantErr: errors.Newf("unsupported public key type <nil>"),
+		},
+		{
+			name:   "zero policy accepts small RSA key",
+			pub:    &prv.PublicKey,
+			policy: KeyPolicy{},
+		},
+		{
+			name:    "default policy rejects 1024 bit RSA key",
+			pub:     &prv.PublicKey,
+			policy:  DefaultKeyPolicy,
+			wantErr: errors.Newf("RSA key of 1024 bits is smaller than the minimum of 2048 bits"),
+		},
+		{
+			name:   "default policy accepts 2048 bit RSA key",
+			pub:    pubKeyRSA,
+			policy: DefaultKeyPolicy,
+		},
+		{
+			name:   "default policy accepts P-256 key",
+			pub:    pubKeyECDSA,
+			policy: DefaultKeyPolicy,
+		},
+		{
+			name:    "default policy rejects P-224 key",
+			pub:     &prvKeyECDSAP224.PublicKey,
+			policy:  DefaultKeyPolicy,
+			wantErr: errors.Newf("ECDSA curve P-224 is not allowed"),
+		},
+		{
+			name:   "default policy accepts ed25519 key",
+			pub:    pubKeyEd25519,
+			policy: DefaultKeyPolicy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkKey(tt.pub)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("checkKey() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+		})
+	}
+}
+
+func TestKeyPolicy_checkAlgorithm(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  KeyPolicy
+		alg     string
+		wantErr error
+	}{
+		{
+			name:    "empty algorithm",
+			wantErr: errors.Newf("unable to determine signature algorithm"),
+		},
+		{
+			name: "zero policy",
+			alg:  "rsa-v1_5-sha256",
+		},
+		{
+			name:   "allowed",
+			policy: KeyPolicy{Algorithms: []string{"rsa-pss-sha512", "ed25519"}},
+			alg:    "ED25519",
+		},
+		{
+			name:    "not allowed",
+			policy:  KeyPolicy{Algorithms: []string{"rsa-pss-sha512", "ed25519"}},
+			alg:     "rsa-v1_5-sha256",
+			wantErr: errors.Newf("signature algorithm rsa-v1_5-sha256 is not allowed"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkAlgorithm(tt.alg)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("checkAlgorithm() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+		})
+	}
+}
+
+func TestKeyPolicy_draftAlgorithms(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy KeyPolicy
+		algs   []draft.Algorithm
+		want   []draft.Algorithm
+	}{
+		{
+			name: "empty",
+			want: []draft.Algorithm{},
+		},
+		{
+			name: "zero policy",
+			algs: []draft.Algorithm{draft.RSA_SHA256, draft.ED25519},
+			want: []draft.Algorithm{draft.RSA_SHA256, draft.ED25519},
+		},
+		{
+			name:   "filtered",
+			policy: KeyPolicy{Algorithms: []string{string(draft.ED25519)}},
+			algs:   []draft.Algorithm{draft.RSA_SHA256, draft.ED25519},
+			want:   []draft.Algorithm{draft.ED25519},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.draftAlgorithms(tt.algs); !cmp.Equal(got, tt.want) {
+				t.Errorf("draftAlgorithms() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
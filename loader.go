@@ -1,8 +1,10 @@
 package auth
 
 import (
-	"fmt"
+	"html/template"
 	"net/http"
+	"net/netip"
+	"time"
 
 	log "git.sr.ht/~mariusor/lw"
 	"github.com/dadrus/httpsig"
@@ -28,20 +30,48 @@ type ActivityPubClient interface {
 }
 
 type config struct {
-	c    ActivityPubClient
-	ncFn httpsig.NonceChecker
-	st   oauthStore
-	l    log.Logger
+	c           ActivityPubClient
+	ncFn        httpsig.NonceChecker
+	st          oauthStore
+	l           log.Logger
+	components  []string
+	policy      KeyPolicy
+	secrets     SecretStore
+	service     vocab.Actor
+	proxies     []netip.Prefix
+	forwarded   ForwardedHeaders
+	scopesFn    ScopesFn
+	clock       ClockFn
+	leeway      time.Duration
+	clientFn    ClientCheckFn
+	jwt         *jwtVerifier
+	tokenLocs   TokenLocation
+	authFn      AuthenticateFn
+	loginTpl    *template.Template
+	consentTpl  *template.Template
+	consentKey  []byte
+	requirePKCE bool
+	statements  *softwareStatements
+	regLimit    *rateLimiter
+	endpoints   Endpoints
+	clientDocs  *clientDocuments
+	fetchPolicy RemoteFetchPolicy
 }
 
 // actorResolver is a used for resolving actors either in local storage or remotely
 type actorResolver config
 
 func Config(initFns ...InitFn) config {
-	c := config{l: log.Nil()}
+	c := config{l: log.Nil(), policy: DefaultKeyPolicy}
 	for _, fn := range initFns {
 		fn(&c)
 	}
+	if c.ncFn == nil {
+		// NOTE(marius): the verifiers created from this config share the nonce store, so the replay
+		// checks work across requests. The nonces are kept for as long as the signatures and DPoP proofs
+		// containing them are accepted, which the leeway extends in both directions.
+		c.ncFn = &syncedNonceStore{ttl: nonceTTL() + 2*c.leeway}
+	}
 	return c
 }
 
@@ -59,18 +89,32 @@ func WithStorage(s oauthStore) InitFn {
 	}
 }
 
+// WithClient sets the client used for fetching the remote keys and the client metadata documents.
+// Its HTTP client should connect using PublicTransport, so the fetches can't reach our internal services,
+// see also WithRemoteFetchPolicy.
 func WithClient(cl ActivityPubClient) InitFn {
 	return func(c *config) {
 		c.c = cl
 	}
 }
 
+// WithNonceChecker sets the store used for detecting replayed signatures and DPoP proofs.
+// When missing, an in memory store is used, which is shared only by the verifiers built from the same options.
 func WithNonceChecker(nc httpsig.NonceChecker) InitFn {
 	return func(c *config) {
 		c.ncFn = nc
 	}
 }
 
+// WithRequiredComponents sets the HTTP fields and derived components that a RFC9421 signature must cover.
+// Draft-cavage signatures must cover the equivalent headers, see draftHeaderName.
+// They are also advertised to clients that failed to authorize, see httpSigVerifier.Challenge.
+func WithRequiredComponents(components ...string) InitFn {
+	return func(c *config) {
+		c.components = components
+	}
+}
+
 func Verifier(initFns ...InitFn) actorResolver {
 	return actorResolver(Config(initFns...))
 }
@@ -81,16 +125,19 @@ func Verifier(initFns ...InitFn) actorResolver {
 // * For OAuth2 it tries to load the matching local actor and use it further in the processing logic.
 // * For HTTP Signatures it tries to load the federated actor and use it further in the processing logic.
 func (a actorResolver) Verify(r *http.Request) (vocab.Actor, error) {
+	res, err := a.VerifyResult(r)
+	return res.Actor, err
+}
+
+// VerifyResult behaves like Verify, but it returns also the details of how the actor was authorized.
+func (a actorResolver) VerifyResult(r *http.Request) (VerificationResult, error) {
 	if a.st == nil {
-		return AnonymousActor, errInvalidStorage
+		return anonymousResult(), errInvalidStorage
 	}
 	if r == nil {
-		return AnonymousActor, nil
+		return anonymousResult(), nil
 	}
 
-	logCtx := log.Ctx{}
-	logCtx["req"] = fmt.Sprintf("%s:%s", r.Method, r.URL.RequestURI())
-
 	var typ string
 	var auth string
 
@@ -98,19 +145,18 @@ func (a actorResolver) Verify(r *http.Request) (vocab.Actor, error) {
 		typ = "Signature"
 	} else if auth = r.Header.Get("Authorization"); auth != "" {
 		typ, _ = getAuthorization(auth)
+	} else if hasAccessToken(r, a.tokenLocs) {
+		typ = "Bearer"
 	}
 
 	switch typ {
-	case "Bearer":
-		ol := oauthVerifier{st: a.st}
-		return ol.Verify(r)
+	case "Bearer", schemeDPoP:
+		ol := newOAuthVerifier(config(a))
+		return ol.VerifyResult(r)
 	case "Signature":
-		kl := httpSigVerifier{
-			loader: &localRemoteLoader{c: a.c, st: a.st},
-			l:      a.l,
-		}
-		return kl.Verify(r)
+		kl := newHTTPSigVerifier(config(a))
+		return kl.VerifyResult(r)
 	default:
-		return AnonymousActor, nil
+		return anonymousResult(), nil
 	}
 }
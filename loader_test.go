@@ -4,8 +4,10 @@ import (
 	"crypto"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 
@@ -20,6 +22,9 @@ import (
 
 func TestConfig(t *testing.T) {
 	mockLogger := lw.Dev(lw.SetOutput(t.Output()))
+	mockNonces := new(syncedNonceStore)
+	mockPolicy := KeyPolicy{MinRSABits: 4096}
+	mockNets := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
 	tests := []struct {
 		name    string
 		initFns []InitFn
@@ -27,17 +32,67 @@ func TestConfig(t *testing.T) {
 	}{
 		{
 			name: "empty",
-			want: config{l: lw.Nil()},
+			want: config{l: lw.Nil(), policy: DefaultKeyPolicy, ncFn: mockNonces},
 		},
 		{
 			name:    "with logger",
 			initFns: []InitFn{WithLogger(mockLogger)},
-			want:    config{l: mockLogger},
+			want:    config{l: mockLogger, policy: DefaultKeyPolicy, ncFn: mockNonces},
 		},
 		{
 			name:    "with storage",
 			initFns: []InitFn{WithStorage(st())},
-			want:    config{st: st(), l: lw.Nil()},
+			want:    config{st: st(), l: lw.Nil(), policy: DefaultKeyPolicy, ncFn: mockNonces},
+		},
+		{
+			name:    "with nonce checker",
+			initFns: []InitFn{WithNonceChecker(new(fileNonceStore))},
+			want:    config{l: lw.Nil(), policy: DefaultKeyPolicy, ncFn: new(fileNonceStore)},
+		},
+		{
+			name: "with signature options",
+			initFns: []InitFn{
+				WithKeyPolicy(mockPolicy),
+				WithRequiredComponents("@method", "@path"),
+				WithTrustedProxies(mockNets...),
+				WithForwardedHeaders(XForwardedHeaders),
+				WithSharedSecrets(nil, mockActor()),
+			},
+			want: config{
+				l:          lw.Nil(),
+				ncFn:       mockNonces,
+				policy:     mockPolicy,
+				components: []string{"@method", "@path"},
+				proxies:    mockNets,
+				forwarded:  XForwardedHeaders,
+				service:    mockActor(),
+			},
+		},
+		{
+			name: "with OAuth2 options",
+			initFns: []InitFn{
+				WithLeeway(time.Minute),
+				WithClock(time.Now),
+				WithTokenLocations(TokenInQuery),
+				WithRequiredPKCE(),
+				WithEndpoints(mockEndpoints),
+				WithClientMetadataDocuments(time.Hour),
+				WithRegistrationLimit(10, time.Minute),
+				WithRemoteFetchPolicy(RemoteFetchPolicy{PublicKeys: true, AllowedNetworks: mockNets}),
+			},
+			want: config{
+				l:           lw.Nil(),
+				ncFn:        mockNonces,
+				policy:      DefaultKeyPolicy,
+				leeway:      time.Minute,
+				clock:       time.Now,
+				tokenLocs:   TokenInQuery,
+				requirePKCE: true,
+				endpoints:   mockEndpoints,
+				clientDocs:  &clientDocuments{},
+				regLimit:    &rateLimiter{},
+				fetchPolicy: RemoteFetchPolicy{PublicKeys: true, AllowedNetworks: mockNets},
+			},
 		},
 	}
 	for _, tt := range tests {
@@ -55,6 +110,23 @@ func areConfig(a, b any) bool {
 	return ok1 && ok2
 }
 
+// sameSetting checks that x and y are both missing, or that they are set to values of the same type.
+// NOTE(marius): we use it for the stores, caches and functions, which we can't compare otherwise.
+func sameSetting(x, y any) bool {
+	xv, yv := reflect.ValueOf(x), reflect.ValueOf(y)
+	if !xv.IsValid() || !yv.IsValid() {
+		return xv.IsValid() == yv.IsValid()
+	}
+	if xv.Type() != yv.Type() {
+		return false
+	}
+	switch xv.Kind() {
+	case reflect.Pointer, reflect.Func, reflect.Interface, reflect.Map, reflect.Slice:
+		return xv.IsNil() == yv.IsNil()
+	}
+	return true
+}
+
 func compareConfig(x, y any) bool {
 	xe := x.(config)
 	ye := y.(config)
@@ -64,6 +136,37 @@ func compareConfig(x, y any) bool {
 	if !reflect.ValueOf(xe.l).Equal(reflect.ValueOf(ye.l)) {
 		return false
 	}
+	settings := [][2]any{
+		{xe.ncFn, ye.ncFn},
+		{xe.secrets, ye.secrets},
+		{xe.scopesFn, ye.scopesFn},
+		{xe.clock, ye.clock},
+		{xe.clientFn, ye.clientFn},
+		{xe.jwt, ye.jwt},
+		{xe.authFn, ye.authFn},
+		{xe.loginTpl, ye.loginTpl},
+		{xe.consentTpl, ye.consentTpl},
+		{xe.statements, ye.statements},
+		{xe.regLimit, ye.regLimit},
+		{xe.clientDocs, ye.clientDocs},
+	}
+	for _, s := range settings {
+		if !sameSetting(s[0], s[1]) {
+			return false
+		}
+	}
+	if !cmp.Equal(xe.policy, ye.policy, cmpopts.EquateEmpty()) || !cmp.Equal(xe.components, ye.components, cmpopts.EquateEmpty()) {
+		return false
+	}
+	if !slices.Equal(xe.proxies, ye.proxies) || xe.forwarded != ye.forwarded || !reflect.DeepEqual(xe.service, ye.service) {
+		return false
+	}
+	if xe.fetchPolicy.PublicKeys != ye.fetchPolicy.PublicKeys || !slices.Equal(xe.fetchPolicy.AllowedNetworks, ye.fetchPolicy.AllowedNetworks) {
+		return false
+	}
+	if xe.leeway != ye.leeway || xe.tokenLocs != ye.tokenLocs || xe.requirePKCE != ye.requirePKCE || xe.endpoints != ye.endpoints || !slices.Equal(xe.consentKey, ye.consentKey) {
+		return false
+	}
 	if xe.st == nil || ye.st == nil {
 		return xe.st == ye.st
 	}
@@ -124,22 +227,22 @@ func TestResolver(t *testing.T) {
 	}{
 		{
 			name: "empty",
-			want: actorResolver{l: lw.Nil()},
+			want: actorResolver{l: lw.Nil(), policy: DefaultKeyPolicy, ncFn: new(syncedNonceStore)},
 		},
 		{
 			name:    "with logger",
 			initFns: []InitFn{WithLogger(mockLogger)},
-			want:    actorResolver{l: mockLogger},
+			want:    actorResolver{l: mockLogger, policy: DefaultKeyPolicy, ncFn: new(syncedNonceStore)},
 		},
 		{
 			name:    "with storage",
 			initFns: []InitFn{WithStorage(st())},
-			want:    actorResolver{st: st(), l: lw.Nil()},
+			want:    actorResolver{st: st(), l: lw.Nil(), policy: DefaultKeyPolicy, ncFn: new(syncedNonceStore)},
 		},
 		{
 			name:    "with client - mostly useless",
 			initFns: []InitFn{WithClient(nil)},
-			want:    actorResolver{c: nil, l: lw.Nil()},
+			want:    actorResolver{c: nil, l: lw.Nil(), policy: DefaultKeyPolicy, ncFn: new(syncedNonceStore)},
 		},
 	}
 	for _, tt := range tests {
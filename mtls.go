@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/go-ap/errors"
+)
+
+// certificateThumbprint returns the value of the "x5t#S256" confirmation of the cert certificate.
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// errCertificateMismatch returns an Unauthorized error with the RFC6750 invalid_token challenge, for
+// certificate-bound tokens presented without the matching certificate.
+func errCertificateMismatch(err error) error {
+	ch := `Bearer error="invalid_token", error_description="The access token is bound to a different client certificate"`
+	return classify(ErrBadSignature, "", "", errors.NewUnauthorized(err, "invalid access token").Challenge(ch))
+}
+
+// checkCertificate verifies that the request was made over a TLS connection authenticated with the client
+// certificate having the x5t thumbprint, as described in RFC8705 section 3.
+// NOTE(marius): when TLS is terminated by a proxy, the request doesn't contain the client certificates,
+// so the certificate-bound tokens can't be used.
+func checkCertificate(r *http.Request, x5t string) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errCertificateMismatch(errors.Newf("access token bound to a client certificate was used without one"))
+	}
+	got := certificateThumbprint(r.TLS.PeerCertificates[0])
+	if subtle.ConstantTimeCompare([]byte(got), []byte(x5t)) != 1 {
+		return errCertificateMismatch(errors.Newf("access token is bound to a different client certificate"))
+	}
+	return nil
+}
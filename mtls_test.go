@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// mockCertificate builds a self-signed client certificate for the key.
+func mockCertificate(t *testing.T, cn string, key crypto.Signer) *x509.Certificate {
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(666),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %s", err)
+	}
+	return cert
+}
+
+func mockTLSReq(tok string, certs ...*x509.Certificate) *http.Request {
+	r := mockDPoPReq("Bearer", tok)
+	if certs != nil {
+		r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	}
+	return r
+}
+
+func TestOAuth2_VerifyResult_mTLS(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cert := mockCertificate(t, "test-client", prvKeyECDSA)
+	other := mockCertificate(t, "other-client", prvKeyEd25519)
+	x5t := certificateThumbprint(cert)
+
+	claims := mockClaims(now)
+	claims.Confirmation = &Confirmation{X5T: x5t}
+	bound := signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, claims)
+	unbound := signJWT(t, "EdDSA", "ed25519", prvKeyEd25519, mockClaims(now))
+
+	actor := vocab.Actor{ID: "http://example.com/~jdoe"}
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		want    vocab.Actor
+		wantErr error
+	}{
+		{
+			name: "matching certificate",
+			r:    mockTLSReq(bound, cert),
+			want: actor,
+		},
+		{
+			name:    "no TLS connection",
+			r:       mockTLSReq(bound),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "no client certificate",
+			r:       mockTLSReq(bound, []*x509.Certificate{}...),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "different certificate",
+			r:       mockTLSReq(bound, other),
+			want:    AnonymousActor,
+			wantErr: ErrBadSignature,
+		},
+		{
+			name: "unbound token with certificate",
+			r:    mockTLSReq(unbound, other),
+			want: actor,
+		},
+	}
+	for _, tt := range tests {
+		s := OAuth2(
+			WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+			WithJWTAccessTokens(jwks{"ed25519": prvKeyEd25519.Public()}, "https://example.com", ""),
+			WithClock(func() time.Time { return now }),
+		)
+		t.Run(tt.name, func(t *testing.T) {
+			verifierTest(s, tt.r, tt.want, tt.wantErr)(t)
+			if tt.wantErr == nil {
+				return
+			}
+			if _, err := s.VerifyResult(tt.r); !errors.IsUnauthorized(err) {
+				t.Errorf("VerifyResult() error = %v, want Unauthorized", err)
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyResult_mTLSOpaqueToken(t *testing.T) {
+	actor := mockActor()
+	cert := mockCertificate(t, "test-client", prvKeyECDSA)
+	x5t := certificateThumbprint(cert)
+	s := OAuth2(WithStorage(mockBoundStore{
+		mockStore: st(&actor, mockAccess("test", defaultClient)),
+		cnf:       map[string]Confirmation{"test": {X5T: x5t}},
+	}))
+
+	got, err := s.VerifyResult(mockTLSReq("test", cert))
+	if err != nil {
+		t.Fatalf("VerifyResult() unexpected error = %v", err)
+	}
+	if got.CertificateThumbprint != x5t {
+		t.Errorf("VerifyResult() certificate thumbprint = %q, want %q", got.CertificateThumbprint, x5t)
+	}
+
+	other := mockCertificate(t, "other-client", prvKeyEd25519)
+	if _, err = s.VerifyResult(mockTLSReq("test", other)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifyResult() with a different certificate error = %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestOAuth2_VerifyResult_confirmationStoreError(t *testing.T) {
+	actor := mockActor()
+	s := OAuth2(WithStorage(mockBoundStore{
+		mockStore: st(&actor, mockAccess("test", defaultClient)),
+		err:       errors.Newf("connection refused"),
+	}))
+
+	_, err := s.VerifyResult(mockTLSReq("test"))
+	if !errors.Is(err, ErrMisconfigured) {
+		t.Errorf("VerifyResult() error = %v, want %v", err, ErrMisconfigured)
+	}
+}
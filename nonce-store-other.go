@@ -0,0 +1,16 @@
+//go:build !unix
+
+package auth
+
+import "os"
+
+// NOTE(marius): on platforms where we don't have advisory locks the file nonce store
+// is safe to use only from a single process.
+
+func lockFile(_ *os.File) error {
+	return nil
+}
+
+func unlockFile(_ *os.File) error {
+	return nil
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dadrus/httpsig"
+	"github.com/go-ap/errors"
+)
+
+// minCompactLines is the number of lines the nonce file can grow to before we try to remove the expired ones.
+const minCompactLines = 1024
+
+// fileNonceStore is a httpsig.NonceChecker which persists the nonces it has seen in a local file.
+// New nonces get appended to the file, which is periodically compacted by replacing it with a copy
+// containing only the ones that didn't expire. Access to the file is serialized using an advisory lock
+// on a separate lock file, so multiple processes running on the same host can share it.
+type fileNonceStore struct {
+	m    sync.Mutex
+	path string
+
+	// seen caches the nonces read from the file up to offset, so on every check we need to read
+	// only the ones appended by other processes in the meantime.
+	seen    map[string]time.Time
+	file    os.FileInfo
+	offset  int64
+	lines   int
+	compact int
+}
+
+// FileNonceStore returns a NonceChecker backed by the file found at path, which gets created if it doesn't exist.
+// Nonces are kept for the maximum age a signature is considered valid, after which they are discarded.
+func FileNonceStore(path string) (*fileNonceStore, error) {
+	for _, p := range []string{path, lockPath(path)} {
+		f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to open nonce store: %s", p)
+		}
+		_ = f.Close()
+	}
+	return &fileNonceStore{path: path}, nil
+}
+
+// lockPath returns the path of the file used for serializing the access to the nonce store.
+// NOTE(marius): we can't lock the nonce file itself, as compacting replaces it.
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// nonceTTL returns the duration for which a nonce needs to be remembered.
+// After this interval passes the signature containing it is rejected as too old anyway.
+func nonceTTL() time.Duration {
+	return sigMaxAgeDuration + sigValidDeltaDuration
+}
+
+// nonceKey hashes the nonce value, so we don't have to care about its contents when storing it.
+func nonceKey(n string) string {
+	sum := sha256.Sum256([]byte(n))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *fileNonceStore) CheckNonce(_ context.Context, n httpsig.NonceValue) error {
+	if !n.Present {
+		return nil
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	lock, err := os.OpenFile(lockPath(s.path), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return errors.Annotatef(err, "unable to open nonce store lock: %s", s.path)
+	}
+	defer func() {
+		_ = lock.Close()
+	}()
+	if err = lockFile(lock); err != nil {
+		return errors.Annotatef(err, "unable to lock nonce store: %s", s.path)
+	}
+	defer func() {
+		_ = unlockFile(lock)
+	}()
+
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Annotatef(err, "unable to open nonce store: %s", s.path)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	now := time.Now()
+	if err = s.load(f, now); err != nil {
+		return errors.Annotatef(err, "unable to read nonce store: %s", s.path)
+	}
+
+	key := nonceKey(n.Value)
+	if expiresAt, exists := s.seen[key]; exists && expiresAt.After(now) {
+		return errInvalidNonce(n.Value)
+	}
+	if err = s.append(f, key, now.Add(nonceTTL())); err != nil {
+		return errors.Annotatef(err, "unable to write nonce store: %s", s.path)
+	}
+	if s.lines >= s.compact {
+		if err = s.compactFile(now); err != nil {
+			return errors.Annotatef(err, "unable to compact nonce store: %s", s.path)
+		}
+	}
+	return nil
+}
+
+// load reads the nonces added to f since the previous check, or all of them when f was replaced in the meantime.
+func (s *fileNonceStore) load(f *os.File, now time.Time) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	reload := s.file == nil || !os.SameFile(s.file, fi) || fi.Size() < s.offset
+	if reload {
+		s.seen = make(map[string]time.Time)
+		s.file, s.offset, s.lines = fi, 0, 0
+	}
+	if fi.Size() > s.offset {
+		if _, err = f.Seek(s.offset, io.SeekStart); err != nil {
+			return err
+		}
+		read, lines, err := readNonces(f, now, s.seen)
+		if err != nil {
+			return err
+		}
+		s.offset += read
+		s.lines += lines
+	}
+	if reload {
+		s.compact = 2*len(s.seen) + minCompactLines
+	}
+	return nil
+}
+
+// append adds the nonce key to the end of f, which has to be opened in append mode.
+func (s *fileNonceStore) append(f *os.File, key string, expiresAt time.Time) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s %d\n", key, expiresAt.Unix())
+	if fi.Size() > s.offset {
+		// NOTE(marius): the file ends with an incomplete line, probably from a write that got interrupted,
+		// so we terminate it, and it gets skipped when reading.
+		line = "\n" + line
+	}
+	if _, err = f.WriteString(line); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	s.seen[key] = expiresAt
+	s.offset = fi.Size() + int64(len(line))
+	s.lines++
+	return nil
+}
+
+// compactFile replaces the nonce file with one containing only the nonces that didn't expire.
+// The new file is written next to the old one and renamed over it, so a crash can't leave us with a partial store.
+func (s *fileNonceStore) compactFile(now time.Time) error {
+	for key, expiresAt := range s.seen {
+		if !expiresAt.After(now) {
+			delete(s.seen, key)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err = writeNonces(tmp, s.seen); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.file, s.offset, s.lines = fi, fi.Size(), len(s.seen)
+	s.compact = 2*len(s.seen) + minCompactLines
+	return nil
+}
+
+// readNonces loads into seen the nonce keys together with their expiration time, skipping the ones that already expired.
+// It returns the number of bytes and of lines it consumed, leaving out an incomplete last line.
+func readNonces(r io.Reader, now time.Time, seen map[string]time.Time) (int64, int, error) {
+	var read int64
+	lines := 0
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return read, lines, nil
+		}
+		if err != nil {
+			return read, lines, err
+		}
+		read += int64(len(line))
+		lines++
+
+		key, exp, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		if !ok {
+			continue
+		}
+		ts, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil {
+			continue
+		}
+		if expiresAt := time.Unix(ts, 0); expiresAt.After(now) {
+			seen[key] = expiresAt
+		}
+	}
+}
+
+// writeNonces writes the nonce keys received to f.
+func writeNonces(f *os.File, seen map[string]time.Time) error {
+	w := bufio.NewWriter(f)
+	for key, expiresAt := range seen {
+		if _, err := fmt.Fprintf(w, "%s %d\n", key, expiresAt.Unix()); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
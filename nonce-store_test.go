@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dadrus/httpsig"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_fileNonceStore_CheckNonce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces")
+	expired := fmt.Sprintf("%s %d\n", nonceKey("expired"), time.Now().Add(-time.Minute).Unix())
+	if err := os.WriteFile(path, []byte(expired), 0o600); err != nil {
+		t.Fatalf("unable to write nonce store: %s", err)
+	}
+
+	first, err := FileNonceStore(path)
+	if err != nil {
+		t.Fatalf("FileNonceStore() unexpected error = %s", err)
+	}
+	// NOTE(marius): the second store simulates a different process sharing the same file
+	second, err := FileNonceStore(path)
+	if err != nil {
+		t.Fatalf("FileNonceStore() unexpected error = %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		st      httpsig.NonceChecker
+		nonce   httpsig.NonceValue
+		wantErr error
+	}{
+		{
+			name:  "not present",
+			st:    first,
+			nonce: httpsig.NonceValue{},
+		},
+		{
+			name:  "first time",
+			st:    first,
+			nonce: httpsig.NonceValue{Present: true, Value: "test"},
+		},
+		{
+			name:    "replay on same store",
+			st:      first,
+			nonce:   httpsig.NonceValue{Present: true, Value: "test"},
+			wantErr: errInvalidNonce("test"),
+		},
+		{
+			name:    "replay on shared store",
+			st:      second,
+			nonce:   httpsig.NonceValue{Present: true, Value: "test"},
+			wantErr: errInvalidNonce("test"),
+		},
+		{
+			name:  "different nonce on shared store",
+			st:    second,
+			nonce: httpsig.NonceValue{Present: true, Value: "test1"},
+		},
+		{
+			name:  "expired nonce",
+			st:    first,
+			nonce: httpsig.NonceValue{Present: true, Value: "expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.st.CheckNonce(context.Background(), tt.nonce)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("CheckNonce() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+		})
+	}
+}
+
+func Test_fileNonceStore_compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces")
+	var expired strings.Builder
+	for i := range minCompactLines {
+		_, _ = fmt.Fprintf(&expired, "%s %d\n", nonceKey(fmt.Sprintf("expired-%d", i)), time.Now().Add(-time.Minute).Unix())
+	}
+	// NOTE(marius): the last line simulates a write interrupted by a crash
+	expired.WriteString("incomplete")
+	if err := os.WriteFile(path, []byte(expired.String()), 0o600); err != nil {
+		t.Fatalf("unable to write nonce store: %s", err)
+	}
+
+	s, err := FileNonceStore(path)
+	if err != nil {
+		t.Fatalf("FileNonceStore() unexpected error = %s", err)
+	}
+	if err = s.CheckNonce(context.Background(), httpsig.NonceValue{Present: true, Value: "test"}); err != nil {
+		t.Fatalf("CheckNonce() unexpected error = %s", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read nonce store: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], nonceKey("test")+" ") {
+		t.Errorf("CheckNonce() didn't compact the nonce store: %q", lines)
+	}
+
+	other, _ := FileNonceStore(path)
+	want := errInvalidNonce("test")
+	if err = other.CheckNonce(context.Background(), httpsig.NonceValue{Present: true, Value: "test"}); !cmp.Equal(err, want, EquateWeakErrors) {
+		t.Errorf("CheckNonce() after compacting error = %s", cmp.Diff(want, err, EquateWeakErrors))
+	}
+}
+
+func Test_readNonces(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	f, err := os.CreateTemp(t.TempDir(), "nonces")
+	if err != nil {
+		t.Fatalf("unable to create nonce store: %s", err)
+	}
+	defer f.Close()
+
+	want := map[string]time.Time{
+		nonceKey("one"): now.Add(time.Minute),
+		nonceKey("two"): now.Add(time.Hour),
+	}
+	if err = writeNonces(f, want); err != nil {
+		t.Fatalf("writeNonces() unexpected error = %s", err)
+	}
+	valid, _ := f.Seek(0, io.SeekCurrent)
+	_, _ = f.WriteString("invalid\n" + nonceKey("three") + " 0\nincomplete")
+	_, _ = f.Seek(0, io.SeekStart)
+
+	got := make(map[string]time.Time)
+	read, lines, err := readNonces(f, now, got)
+	if err != nil {
+		t.Fatalf("readNonces() unexpected error = %s", err)
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("readNonces() got = %s", cmp.Diff(want, got))
+	}
+	if wantRead := valid + int64(len("invalid\n"+nonceKey("three")+" 0\n")); read != wantRead || lines != 4 {
+		t.Errorf("readNonces() read %d bytes and %d lines, want %d and 4", read, lines, wantRead)
+	}
+}
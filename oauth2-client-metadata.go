@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+const (
+	// maxClientMetadataSize is the size limit of the client metadata documents.
+	maxClientMetadataSize = 64 << 10
+	// maxClientMetadataEntries is the number of client metadata documents we keep in the cache.
+	maxClientMetadataEntries = 1024
+	// clientMetadataFailureTTL is the time we remember that a client metadata document couldn't be loaded.
+	clientMetadataFailureTTL = time.Minute
+	// maxClientMetadataFetches is the number of client metadata documents we fetch from a host per minute.
+	maxClientMetadataFetches = 10
+)
+
+// clientMetadataDocument is the JSON document identified by an URL client_id, as described in
+// the OAuth Client ID Metadata Document draft.
+type clientMetadataDocument struct {
+	ClientID string `json:"client_id"`
+	ClientRegistration
+}
+
+// clientDocuments caches the clients loaded from their metadata documents, and the failures to load them.
+type clientDocuments struct {
+	ttl     time.Duration
+	fetches *rateLimiter
+
+	m       sync.Mutex
+	entries map[string]clientDocumentEntry
+}
+
+type clientDocumentEntry struct {
+	cl      osin.Client
+	err     error
+	expires time.Time
+}
+
+// WithClientMetadataDocuments allows the OAuth2 clients to use as client_id the HTTPS URL of a JSON document
+// containing their metadata, instead of registering. The documents are fetched using the ActivityPubClient set with
+// WithClient, and they are cached for ttl. The documents that can't be loaded are not fetched again for a minute,
+// and at most ten documents per minute are fetched from the same host, so the clients can't use us for flooding it.
+// These clients are public, so they must use PKCE, and their redirect URIs must be listed in the document.
+func WithClientMetadataDocuments(ttl time.Duration) InitFn {
+	return func(c *config) {
+		c.clientDocs = &clientDocuments{
+			ttl:     ttl,
+			fetches: newRateLimiter(maxClientMetadataFetches, time.Minute),
+			entries: make(map[string]clientDocumentEntry),
+		}
+	}
+}
+
+// isURLClientID checks if the OAuth2 client_id is a URL, rather than an identifier issued by us.
+func isURLClientID(id string) bool {
+	return strings.HasPrefix(id, "https://") || strings.HasPrefix(id, "http://")
+}
+
+// validateClientIDURL checks that the id client_id can identify a metadata document: it must be an HTTPS URL
+// with a path, without dot segments, fragment, or credentials, and pointing to a public host, or one in
+// the allowed networks.
+func validateClientIDURL(id string, allowed ...netip.Prefix) error {
+	u, err := url.Parse(id)
+	if err != nil {
+		return errors.Annotatef(err, "invalid client_id URL")
+	}
+	if u.Scheme != "https" {
+		return errors.Newf("client_id URL must use https")
+	}
+	if u.Path == "" || u.Path == "/" || u.Fragment != "" || strings.Contains(id, "#") {
+		return errors.Newf("client_id URL must have a path, and no fragment")
+	}
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg == "." || seg == ".." {
+			return errors.Newf("client_id URL must not contain dot segments")
+		}
+	}
+	return checkPublicURL(u, allowed...)
+}
+
+// clientDocument loads the client for osin, which reports any client it can't find as invalid_client.
+// NOTE(marius): these clients don't exist in the storage, so the storage backends must keep the client
+// of the authorization codes and access tokens they save, instead of loading it again by its ID.
+func (k oauthVerifier) clientDocument(ctx context.Context, id string) (osin.Client, error) {
+	cl, err := k.loadClientDocument(ctx, id)
+	if err != nil {
+		k.l.WithContext(lw.Ctx{"client": id, "err": err.Error()}).Warnf("unable to load client metadata document")
+		return nil, osin.ErrNotFound
+	}
+	return cl, nil
+}
+
+// loadClientDocument returns the client described by the metadata document at the id URL.
+func (k oauthVerifier) loadClientDocument(ctx context.Context, id string) (osin.Client, error) {
+	if e, ok := k.clientDocs.get(id, k.now()); ok {
+		return e.cl, e.err
+	}
+	if err := validateClientIDURL(id, k.fetchPolicy.AllowedNetworks...); err != nil {
+		return nil, err
+	}
+	if k.c == nil {
+		return nil, errInvalidClient
+	}
+	u, _ := url.Parse(id)
+	if _, ok := k.clientDocs.fetches.allow(u.Host, k.now()); !ok {
+		return nil, errors.Newf("too many client metadata documents fetched from %s", u.Host)
+	}
+
+	cl, err := k.fetchClientDocument(ctx, id)
+	if err != nil && ctx.Err() != nil {
+		// NOTE(marius): the request was canceled, which doesn't tell anything about the document.
+		return nil, err
+	}
+	k.clientDocs.put(id, clientDocumentEntry{cl: cl, err: err}, k.now())
+	return cl, err
+}
+
+// fetchClientDocument loads the client metadata document at the id URL and checks that it describes a public client.
+func (k oauthVerifier) fetchClientDocument(ctx context.Context, id string) (osin.Client, error) {
+	status, body, err := fetchRemote(ctx, k.c, id, "application/json", maxClientMetadataSize, k.fetchPolicy.checkPublic)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to fetch client metadata")
+	}
+	if status != http.StatusOK {
+		return nil, errors.NewFromStatus(status, "unable to fetch client metadata: %s", id)
+	}
+
+	doc := clientMetadataDocument{}
+	if err = json.Unmarshal(body, &doc); err != nil {
+		return nil, errors.Annotatef(err, "unable to decode client metadata: %s", id)
+	}
+	if doc.ClientID != id {
+		return nil, errors.Newf("client metadata is for a different client_id: %s", doc.ClientID)
+	}
+	// NOTE(marius): anybody can read the document, so it can't hold a secret the client would authenticate with.
+	if doc.TokenEndpointAuthMethod != "" && doc.TokenEndpointAuthMethod != authMethodNone {
+		return nil, errors.Newf("unsupported token_endpoint_auth_method %q", doc.TokenEndpointAuthMethod)
+	}
+	doc.TokenEndpointAuthMethod = authMethodNone
+	if _, err = doc.validate(); err != nil {
+		return nil, err
+	}
+
+	return &osin.DefaultClient{
+		Id:          id,
+		RedirectUri: strings.Join(doc.RedirectURIs, redirectURISeparator),
+		UserData:    doc.ClientRegistration,
+	}, nil
+}
+
+func (d *clientDocuments) get(id string, now time.Time) (clientDocumentEntry, bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	e, ok := d.entries[id]
+	if !ok || now.After(e.expires) {
+		return clientDocumentEntry{}, false
+	}
+	return e, true
+}
+
+// put caches the result of loading the id document, the failures being kept for a shorter time.
+func (d *clientDocuments) put(id string, e clientDocumentEntry, now time.Time) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if len(d.entries) >= maxClientMetadataEntries {
+		for k, e := range d.entries {
+			if now.After(e.expires) {
+				delete(d.entries, k)
+			}
+		}
+	}
+	if len(d.entries) >= maxClientMetadataEntries {
+		// NOTE(marius): the cache is full of fresh entries, we evict the one closest to expiring.
+		keys := make([]string, 0, len(d.entries))
+		for k := range d.entries {
+			keys = append(keys, k)
+		}
+		oldest := slices.MinFunc(keys, func(a, b string) int { return d.entries[a].expires.Compare(d.entries[b].expires) })
+		delete(d.entries, oldest)
+	}
+	ttl := d.ttl
+	if e.err != nil {
+		ttl = min(ttl, clientMetadataFailureTTL)
+	}
+	e.expires = now.Add(ttl)
+	d.entries[id] = e
+}
@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+const mockClientID = "https://app.example.com/client.json"
+
+// mockDocClient serves the client metadata documents from memory, counting the requests it receives.
+type mockDocClient struct {
+	docs  map[string]any
+	calls int
+	last  *http.Request
+}
+
+func (m *mockDocClient) Do(r *http.Request) (*http.Response, error) {
+	m.calls++
+	m.last = r
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+	w := httptest.NewRecorder()
+	doc, ok := m.docs[r.URL.String()]
+	switch {
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Header.Get("Accept") != "application/json":
+		w.WriteHeader(http.StatusNotAcceptable)
+	default:
+		raw, isRaw := doc.(string)
+		if !isRaw {
+			b, _ := json.Marshal(doc)
+			raw = string(b)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.WriteString(raw)
+	}
+	return w.Result(), nil
+}
+
+func (m *mockDocClient) LoadIRI(id vocab.IRI) (vocab.Item, error) {
+	return nil, nil
+}
+
+func docClient(docs map[string]any) *mockDocClient {
+	return &mockDocClient{docs: docs}
+}
+
+func mockClientDocument(id string, redirects ...string) clientMetadataDocument {
+	return clientMetadataDocument{
+		ClientID: id,
+		ClientRegistration: ClientRegistration{
+			RedirectURIs: redirects,
+			ClientName:   "App",
+		},
+	}
+}
+
+func Test_validateClientIDURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		allowed []netip.Prefix
+		wantErr bool
+	}{
+		{name: "valid", id: mockClientID},
+		{name: "http", id: "http://app.example.com/client.json", wantErr: true},
+		{name: "no path", id: "https://app.example.com", wantErr: true},
+		{name: "root path", id: "https://app.example.com/", wantErr: true},
+		{name: "fragment", id: "https://app.example.com/client.json#x", wantErr: true},
+		{name: "dot segment", id: "https://app.example.com/a/../client.json", wantErr: true},
+		{name: "credentials", id: "https://user:pw@app.example.com/client.json", wantErr: true},
+		{name: "localhost", id: "https://localhost/client.json", wantErr: true},
+		{name: "loopback", id: "https://127.0.0.1/client.json", wantErr: true},
+		{name: "private network", id: "https://10.0.0.1/client.json", wantErr: true},
+		{name: "allowed private network", id: "https://10.0.0.1/client.json", allowed: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		{name: "link local", id: "https://[fe80::1]/client.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateClientIDURL(tt.id, tt.allowed...); (err != nil) != tt.wantErr {
+				t.Errorf("validateClientIDURL() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_oauthVerifier_loadClientDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		doc     any
+		want    osin.Client
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			id:   mockClientID,
+			doc:  mockClientDocument(mockClientID, "https://app.example.com/callback", "com.example.app:/callback"),
+			want: &osin.DefaultClient{
+				Id:          mockClientID,
+				RedirectUri: "https://app.example.com/callback\ncom.example.app:/callback",
+				UserData: ClientRegistration{
+					RedirectURIs:            []string{"https://app.example.com/callback", "com.example.app:/callback"},
+					TokenEndpointAuthMethod: authMethodNone,
+					GrantTypes:              []string{string(osin.AUTHORIZATION_CODE)},
+					ResponseTypes:           []string{string(osin.CODE)},
+					ClientName:              "App",
+				},
+			},
+		},
+		{
+			name:    "different client_id",
+			id:      mockClientID,
+			doc:     mockClientDocument("https://evil.example.com/client.json", "https://app.example.com/callback"),
+			wantErr: true,
+		},
+		{
+			name: "secret authentication",
+			id:   mockClientID,
+			doc: func() clientMetadataDocument {
+				d := mockClientDocument(mockClientID, "https://app.example.com/callback")
+				d.TokenEndpointAuthMethod = authMethodBasic
+				return d
+			}(),
+			wantErr: true,
+		},
+		{
+			name:    "invalid redirect URI",
+			id:      mockClientID,
+			doc:     mockClientDocument(mockClientID, "http://app.example.com/callback"),
+			wantErr: true,
+		},
+		{
+			name:    "no redirect URIs",
+			id:      mockClientID,
+			doc:     mockClientDocument(mockClientID),
+			wantErr: true,
+		},
+		{
+			name:    "not JSON",
+			id:      mockClientID,
+			doc:     "<html></html>",
+			wantErr: true,
+		},
+		{
+			name:    "too large",
+			id:      mockClientID,
+			doc:     `{"client_id":"` + mockClientID + `","client_name":"` + strings.Repeat("a", maxClientMetadataSize) + `"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not found",
+			id:      "https://app.example.com/missing.json",
+			wantErr: true,
+		},
+		{
+			name:    "private network",
+			id:      "https://10.0.0.1/client.json",
+			doc:     mockClientDocument("https://10.0.0.1/client.json", "https://app.example.com/callback"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := docClient(map[string]any{tt.id: tt.doc})
+			if tt.doc == nil {
+				c.docs = nil
+			}
+			k := OAuth2(WithClient(c), WithClientMetadataDocuments(time.Hour))
+			got, err := k.loadClientDocument(context.Background(), tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadClientDocument() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("loadClientDocument() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func Test_oauthVerifier_loadClientDocument_cache(t *testing.T) {
+	now := time.Now()
+	c := docClient(map[string]any{mockClientID: mockClientDocument(mockClientID, "https://app.example.com/callback")})
+	k := OAuth2(
+		WithClient(c),
+		WithClientMetadataDocuments(time.Hour),
+		WithClock(func() time.Time { return now }),
+	)
+
+	for range 3 {
+		if _, err := k.loadClientDocument(context.Background(), mockClientID); err != nil {
+			t.Fatalf("loadClientDocument() error = %v", err)
+		}
+	}
+	if c.calls != 1 {
+		t.Errorf("loadClientDocument() fetched the document %d times, want 1", c.calls)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := k.loadClientDocument(context.Background(), mockClientID); err != nil {
+		t.Fatalf("loadClientDocument() error = %v", err)
+	}
+	if c.calls != 2 {
+		t.Errorf("loadClientDocument() fetched the document %d times after expiring, want 2", c.calls)
+	}
+}
+
+func Test_oauthVerifier_loadClientDocument_failures(t *testing.T) {
+	now := time.Now()
+	c := docClient(nil)
+	k := OAuth2(
+		WithClient(c),
+		WithClientMetadataDocuments(time.Hour),
+		WithClock(func() time.Time { return now }),
+	)
+
+	for range 3 {
+		if _, err := k.loadClientDocument(context.Background(), mockClientID); err == nil {
+			t.Fatalf("loadClientDocument() for a missing document returned no error")
+		}
+	}
+	if c.calls != 1 {
+		t.Errorf("loadClientDocument() fetched the missing document %d times, want 1", c.calls)
+	}
+	if _, ok := c.last.Context().Deadline(); !ok {
+		t.Errorf("loadClientDocument() fetched the document without a deadline")
+	}
+
+	now = now.Add(clientMetadataFailureTTL + time.Second)
+	c.docs = map[string]any{mockClientID: mockClientDocument(mockClientID, "https://app.example.com/callback")}
+	if _, err := k.loadClientDocument(context.Background(), mockClientID); err != nil {
+		t.Fatalf("loadClientDocument() error = %v", err)
+	}
+	if c.calls != 2 {
+		t.Errorf("loadClientDocument() fetched the document %d times after the failure expired, want 2", c.calls)
+	}
+}
+
+func Test_oauthVerifier_loadClientDocument_canceled(t *testing.T) {
+	c := docClient(map[string]any{mockClientID: mockClientDocument(mockClientID, "https://app.example.com/callback")})
+	k := OAuth2(WithClient(c), WithClientMetadataDocuments(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := k.loadClientDocument(ctx, mockClientID); err == nil {
+		t.Fatalf("loadClientDocument() for a canceled request returned no error")
+	}
+	if _, err := k.loadClientDocument(context.Background(), mockClientID); err != nil {
+		t.Errorf("loadClientDocument() the failure of the canceled request was cached: %v", err)
+	}
+}
+
+func Test_oauthVerifier_loadClientDocument_limit(t *testing.T) {
+	c := docClient(nil)
+	k := OAuth2(WithClient(c), WithClientMetadataDocuments(time.Hour))
+
+	for i := range maxClientMetadataFetches {
+		_, _ = k.loadClientDocument(context.Background(), fmt.Sprintf("https://app.example.com/client-%d.json", i))
+	}
+	if _, err := k.loadClientDocument(context.Background(), "https://app.example.com/client.json"); err == nil {
+		t.Errorf("loadClientDocument() over the limit returned no error")
+	}
+	if c.calls != maxClientMetadataFetches {
+		t.Errorf("loadClientDocument() fetched %d documents, want %d", c.calls, maxClientMetadataFetches)
+	}
+	if _, err := k.loadClientDocument(context.Background(), "https://other.example.com/client.json"); err == nil || c.calls != maxClientMetadataFetches+1 {
+		t.Errorf("loadClientDocument() for another host error = %v, fetched %d documents", err, c.calls)
+	}
+}
+
+func TestAuthorizeHandler_clientMetadataDocument(t *testing.T) {
+	actor := mockActor()
+	tests := []struct {
+		name        string
+		fns         []InitFn
+		redirectURI string
+		wantError   string
+	}{
+		{
+			name:        "valid",
+			redirectURI: "https://app.example.com/callback",
+			fns:         []InitFn{WithClientMetadataDocuments(time.Hour)},
+		},
+		{
+			name:        "redirect URI not in document",
+			redirectURI: "https://evil.example.com/callback",
+			fns:         []InitFn{WithClientMetadataDocuments(time.Hour)},
+			wantError:   osin.E_INVALID_REQUEST,
+		},
+		{
+			name:        "disabled",
+			redirectURI: "https://app.example.com/callback",
+			wantError:   osin.E_UNAUTHORIZED_CLIENT,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := authStore(nil, &actor)
+			c := docClient(map[string]any{mockClientID: mockClientDocument(mockClientID, "https://app.example.com/callback")})
+			fns := append([]InitFn{
+				WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+				WithStorage(st),
+				WithClient(c),
+				WithAuthenticator(PasswordAuthenticator(st, mockIRIFn)),
+			}, tt.fns...)
+
+			r := mockPKCEAuthorizeReq(mockClientID, mockChallenge(mockVerifier), osin.PKCE_S256)
+			q := r.URL.Query()
+			q.Set("redirect_uri", tt.redirectURI)
+			r.URL.RawQuery = q.Encode()
+
+			h := AuthorizeHandler(fns...)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			w = consent(t, h, r, w, consentApprove)
+			if tt.wantError == "" {
+				loc, _ := url.Parse(w.Header().Get("Location"))
+				if w.Code != http.StatusFound || loc.Query().Get("code") == "" {
+					t.Fatalf("ServeHTTP() = %d %s, want a redirect with a code", w.Code, w.Header().Get("Location"))
+				}
+				return
+			}
+			if w.Code == http.StatusFound && strings.HasPrefix(w.Header().Get("Location"), tt.redirectURI) {
+				t.Fatalf("ServeHTTP() redirected to %s", w.Header().Get("Location"))
+			}
+			if !strings.Contains(w.Body.String(), tt.wantError) && !strings.Contains(w.Header().Get("Location"), tt.wantError) {
+				t.Errorf("ServeHTTP() = %d %s, want error %q", w.Code, w.Body.String(), tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+// ClientCheckFn checks if the tokens issued to an OAuth2 client can still be used.
+// It returns an error for clients that have been revoked or disabled.
+type ClientCheckFn func(osin.Client) error
+
+// WithClientCheck sets the function that checks the OAuth2 client of the access tokens, which allows operators
+// to revoke, or disable temporarily, all the tokens issued to an application.
+func WithClientCheck(fn ClientCheckFn) InitFn {
+	return func(c *config) {
+		c.clientFn = fn
+	}
+}
+
+// DisabledClients returns a ClientCheckFn that rejects the clients with the ids.
+func DisabledClients(ids ...string) ClientCheckFn {
+	return func(cl osin.Client) error {
+		if slices.Contains(ids, cl.GetId()) {
+			return errors.Forbiddenf("client %s is disabled", cl.GetId())
+		}
+		return nil
+	}
+}
+
+// revokedTokenChallenge is the WWW-Authenticate challenge described in RFC6750 for revoked access tokens.
+const revokedTokenChallenge = `Bearer error="invalid_token", error_description="The access token was revoked"`
+
+// checkClient returns an error if the OAuth2 client the access token was issued to is no longer allowed.
+func (k oauthVerifier) checkClient(cl osin.Client) error {
+	if k.clientFn == nil || cl == nil {
+		return nil
+	}
+	if err := k.clientFn(cl); err != nil {
+		err = errors.NewUnauthorized(err, "client %s is not allowed", cl.GetId()).Challenge(revokedTokenChallenge)
+		return classify(ErrRevoked, "", "", err)
+	}
+	return nil
+}
+
+// clientStore is implemented by the OAuth2 storage backends that can load clients, it is used
+// for authenticating the callers of the token endpoints using their client credentials.
+type clientStore interface {
+	GetClient(id string) (osin.Client, error)
+}
+
+// hasClientCredentials checks if the request contains client credentials, either in the Authorization header
+// or in the form parameters, as described in RFC6749 section 2.3.1.
+func hasClientCredentials(r *http.Request) bool {
+	if typ, _ := getAuthorization(r.Header.Get("Authorization")); typ == "Basic" {
+		return true
+	}
+	return r.PostFormValue("client_id") != ""
+}
+
+// authenticateClient loads the OAuth2 client of the request and checks its credentials.
+func (k oauthVerifier) authenticateClient(r *http.Request) (osin.Client, error) {
+	if k.st == nil {
+		return nil, errInvalidStorage
+	}
+	clients, ok := k.st.(clientStore)
+	if !ok {
+		return nil, classify(ErrMissingCredentials, "", "", errors.Unauthorizedf("client credentials are not supported"))
+	}
+
+	id, secret := r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	if typ, _ := getAuthorization(r.Header.Get("Authorization")); typ == "Basic" {
+		basic, err := osin.CheckBasicAuth(r)
+		if err != nil {
+			return nil, classify(ErrMalformed, "", "", errors.NewBadRequest(err, "invalid basic authorization"))
+		}
+		id, secret = basic.Username, basic.Password
+	}
+	if id == "" {
+		return nil, classify(ErrMissingCredentials, "", "", errors.Unauthorizedf("missing client credentials"))
+	}
+
+	cl, err := clients.GetClient(id)
+	if err != nil {
+		return nil, errUnauthorized(ErrUnknownKey, err)
+	}
+	if cl == nil || !osin.CheckClientSecret(cl, secret) {
+		return nil, classify(ErrBadSignature, "", "", errors.Unauthorizedf("invalid client credentials"))
+	}
+	if err = k.checkClient(cl); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
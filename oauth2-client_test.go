@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+func TestDisabledClients(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		cl      osin.Client
+		wantErr error
+	}{
+		{
+			name: "none disabled",
+			cl:   defaultClient,
+		},
+		{
+			name: "other client disabled",
+			ids:  []string{"other-client"},
+			cl:   defaultClient,
+		},
+		{
+			name:    "client disabled",
+			ids:     []string{"other-client", "test-client"},
+			cl:      defaultClient,
+			wantErr: errors.Forbiddenf("client test-client is disabled"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DisabledClients(tt.ids...)(tt.cl)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("DisabledClients() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyAccessCodeResult_client(t *testing.T) {
+	actor := mockActor()
+	metadata := map[string]string{"name": "Test application"}
+	client := &osin.DefaultClient{
+		Id:          "test-client",
+		Secret:      "asd",
+		RedirectUri: "http://example.com/callback",
+		UserData:    metadata,
+	}
+
+	tests := []struct {
+		name     string
+		clientFn ClientCheckFn
+		want     VerificationResult
+		wantErr  error
+	}{
+		{
+			name: "client details",
+			want: VerificationResult{
+				Method:            MethodOAuth2,
+				Actor:             actor,
+				Scopes:            []string{"none"},
+				ClientID:          "test-client",
+				ClientRedirectURI: "http://example.com/callback",
+				ClientMetadata:    metadata,
+			},
+		},
+		{
+			name:     "allowed client",
+			clientFn: DisabledClients("other-client"),
+			want: VerificationResult{
+				Method:            MethodOAuth2,
+				Actor:             actor,
+				Scopes:            []string{"none"},
+				ClientID:          "test-client",
+				ClientRedirectURI: "http://example.com/callback",
+				ClientMetadata:    metadata,
+			},
+		},
+		{
+			name:     "disabled client",
+			clientFn: DisabledClients("test-client"),
+			want:     anonymousResult(),
+			wantErr:  errors.NewUnauthorized(errors.Forbiddenf("client test-client is disabled"), "client test-client is not allowed"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := oauthVerifier{
+				st:       st(&actor, mockAccess("test", client)),
+				l:        lw.Dev(lw.SetOutput(t.Output())),
+				clientFn: tt.clientFn,
+			}
+
+			got, err := s.VerifyAccessCodeResult("test")
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("VerifyAccessCodeResult() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyAccessCodeResult() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+			if tt.wantErr == nil {
+				return
+			}
+			if !errors.Is(err, ErrRevoked) {
+				t.Errorf("VerifyAccessCodeResult() error %v is not %v", err, ErrRevoked)
+			}
+			if ch := errors.Challenge(err); ch != revokedTokenChallenge {
+				t.Errorf("VerifyAccessCodeResult() challenge = %q, want %q", ch, revokedTokenChallenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/dadrus/httpsig"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+const (
+	// consentTTL is the time the resource owner has for approving an authorization request.
+	consentTTL = 10 * time.Minute
+
+	// consentTokenField is the name of the form field carrying the consent token.
+	consentTokenField = "consent_token"
+	// consentField is the name of the form field carrying the decision of the resource owner.
+	consentField   = "consent"
+	consentApprove = "approve"
+)
+
+// WithConsentTemplate replaces the form shown by the authorization endpoint to the authenticated actors,
+// for approving or denying the access of the client.
+// The template receives the Action URL the form must be posted to, the ClientID, the requested Scope,
+// the Actor IRI and the Token that must be posted back in the "consent_token" field, together with
+// a "consent" field with the "approve" value for granting the access.
+func WithConsentTemplate(t *template.Template) InitFn {
+	return func(c *config) {
+		c.consentTpl = t
+	}
+}
+
+// WithConsentKey sets the key used for signing the consent tokens.
+// When missing, every AuthorizeHandler uses a random key, so the instances of a load balanced
+// authorization endpoint need to share it, together with the nonce store which makes the tokens single use.
+func WithConsentKey(key []byte) InitFn {
+	return func(c *config) {
+		c.consentKey = key
+	}
+}
+
+// consentLifetime returns the time the consent tokens are valid for, which must not exceed the time
+// their IDs are remembered by the nonce store, for them to be used only once.
+func consentLifetime() time.Duration {
+	return min(consentTTL, nonceTTL())
+}
+
+// consentClaims binds the consent of the resource owner to the authorization request that was presented to them.
+// NOTE(marius): the osin authorization request parameters can be overridden in the POSTed form, so all the
+// ones that end up in the issued code are included.
+type consentClaims struct {
+	ID                  string    `json:"jti"`
+	Subject             vocab.IRI `json:"sub"`
+	ClientID            string    `json:"client_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scope               string    `json:"scope,omitempty"`
+	State               string    `json:"state,omitempty"`
+	CodeChallenge       string    `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string    `json:"code_challenge_method,omitempty"`
+	ExpiresAt           int64     `json:"exp"`
+}
+
+func newConsentClaims(ar *osin.AuthorizeRequest, sub vocab.IRI) consentClaims {
+	return consentClaims{
+		Subject:             sub,
+		ClientID:            ar.Client.GetId(),
+		RedirectURI:         ar.RedirectUri,
+		Scope:               ar.Scope,
+		State:               ar.State,
+		CodeChallenge:       ar.CodeChallenge,
+		CodeChallengeMethod: ar.CodeChallengeMethod,
+	}
+}
+
+// randomKey returns a new random key for signing the consent tokens.
+func randomKey() []byte {
+	key := make([]byte, sha256.Size)
+	_, _ = rand.Read(key)
+	return key
+}
+
+func consentMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// signConsent returns the consent token for the authorization request, which expires after consentLifetime.
+func signConsent(key []byte, c consentClaims, now time.Time) string {
+	c.ID = randomToken(16)
+	c.ExpiresAt = now.Add(consentLifetime()).Unix()
+	raw, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(consentMAC(key, payload))
+}
+
+// verifyConsent checks that tok was issued by us for the authorization request, and returns its claims,
+// which hold the IRI of the actor that was presented the consent form.
+func verifyConsent(key []byte, tok string, ar *osin.AuthorizeRequest, now time.Time) (consentClaims, error) {
+	c := consentClaims{}
+	payload, sig, ok := strings.Cut(tok, ".")
+	if !ok {
+		return c, errors.Forbiddenf("malformed consent token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, consentMAC(key, payload)) {
+		return c, errors.Forbiddenf("invalid consent token")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return c, errors.Forbiddenf("malformed consent token")
+	}
+	if err = json.Unmarshal(raw, &c); err != nil || c.ID == "" {
+		return consentClaims{}, errors.Forbiddenf("malformed consent token")
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0)) {
+		return consentClaims{}, errors.Forbiddenf("consent token expired")
+	}
+	want := newConsentClaims(ar, c.Subject)
+	want.ID = c.ID
+	want.ExpiresAt = c.ExpiresAt
+	if c != want || c.Subject == "" {
+		return consentClaims{}, errors.Forbiddenf("consent token was issued for a different authorization request")
+	}
+	return c, nil
+}
+
+// useConsent records the ID of the consent token in the nonce store, so it can be used only once.
+func (h authorizeHandler) useConsent(ctx context.Context, c consentClaims) error {
+	if h.ncFn == nil {
+		return nil
+	}
+	n := httpsig.NonceValue{Present: true, Value: nonceKey(consentTokenField + "\n" + c.ID)}
+	if err := h.ncFn.CheckNonce(ctx, n); err != nil {
+		return errors.NewForbidden(err, "consent token was already used")
+	}
+	return nil
+}
+
+var defaultConsentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Authorize</title></head>
+<body>
+<form method="post" action="{{ .Action }}">
+<p>Allow <strong>{{ .ClientID }}</strong> to access <em>{{ .Actor }}</em>{{ if .Scope }} for <em>{{ .Scope }}</em>{{ end }}?</p>
+<input type="hidden" name="consent_token" value="{{ .Token }}">
+<button type="submit" name="consent" value="approve">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
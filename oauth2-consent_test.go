@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+func Test_verifyConsent(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	key := []byte("consent-key")
+	ar := &osin.AuthorizeRequest{
+		Client: defaultClient, RedirectUri: "http://example.com", Scope: "read", State: "xyz",
+		CodeChallenge: mockChallenge(mockVerifier), CodeChallengeMethod: osin.PKCE_S256,
+	}
+	sub := vocab.IRI("http://example.com/~jdoe")
+	tok := signConsent(key, newConsentClaims(ar, sub), now)
+	other := func(fn func(ar *osin.AuthorizeRequest)) *osin.AuthorizeRequest {
+		o := *ar
+		fn(&o)
+		return &o
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		tok     string
+		ar      *osin.AuthorizeRequest
+		now     time.Time
+		want    vocab.IRI
+		wantErr error
+	}{
+		{
+			name: "valid",
+			key:  key,
+			tok:  tok,
+			ar:   ar,
+			now:  now,
+			want: sub,
+		},
+		{
+			name:    "malformed",
+			key:     key,
+			tok:     "invalid",
+			ar:      ar,
+			now:     now,
+			wantErr: errors.Forbiddenf("malformed consent token"),
+		},
+		{
+			name:    "another key",
+			key:     []byte("other-key"),
+			tok:     tok,
+			ar:      ar,
+			now:     now,
+			wantErr: errors.Forbiddenf("invalid consent token"),
+		},
+		{
+			name:    "expired",
+			key:     key,
+			tok:     tok,
+			ar:      ar,
+			now:     now.Add(consentTTL + time.Second),
+			wantErr: errors.Forbiddenf("consent token expired"),
+		},
+		{
+			name:    "another scope",
+			key:     key,
+			tok:     tok,
+			ar:      other(func(ar *osin.AuthorizeRequest) { ar.Scope = "read write" }),
+			now:     now,
+			wantErr: errors.Forbiddenf("consent token was issued for a different authorization request"),
+		},
+		{
+			name:    "another redirect URI",
+			key:     key,
+			tok:     tok,
+			ar:      other(func(ar *osin.AuthorizeRequest) { ar.RedirectUri = "http://example.org" }),
+			now:     now,
+			wantErr: errors.Forbiddenf("consent token was issued for a different authorization request"),
+		},
+		{
+			name:    "another code challenge",
+			key:     key,
+			tok:     tok,
+			ar:      other(func(ar *osin.AuthorizeRequest) { ar.CodeChallenge = mockChallenge("other") }),
+			now:     now,
+			wantErr: errors.Forbiddenf("consent token was issued for a different authorization request"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyConsent(tt.key, tt.tok, tt.ar, tt.now)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("verifyConsent() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if got.Subject != tt.want {
+				t.Errorf("verifyConsent() = %q, want %q", got.Subject, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+// ClockFn returns the current time.
+type ClockFn func() time.Time
+
+// WithClock sets the function used for getting the current time when checking the expiry of access tokens,
+// and the freshness of the signatures.
+// It is mostly useful for testing.
+func WithClock(fn ClockFn) InitFn {
+	return func(c *config) {
+		c.clock = fn
+	}
+}
+
+// WithLeeway sets the duration for which access tokens are still accepted after they expired,
+// to allow for clock skew between the authorization server and us.
+func WithLeeway(d time.Duration) InitFn {
+	return func(c *config) {
+		c.leeway = d
+	}
+}
+
+// invalidTokenChallenge is the WWW-Authenticate challenge described in RFC6750 for expired access tokens.
+const invalidTokenChallenge = `Bearer error="invalid_token", error_description="The access token expired"`
+
+func (k oauthVerifier) now() time.Time {
+	if k.clock == nil {
+		return time.Now()
+	}
+	return k.clock()
+}
+
+// checkExpiry returns an error if the access token expired more than the leeway ago.
+func (k oauthVerifier) checkExpiry(dat *osin.AccessData) error {
+	if !dat.IsExpiredAt(k.now().Add(-k.leeway)) {
+		return nil
+	}
+	err := errors.Unauthorizedf("access token expired at %s", dat.ExpireAt().UTC().Format(time.RFC3339))
+	return classify(ErrExpired, "", "", err.Challenge(invalidTokenChallenge))
+}
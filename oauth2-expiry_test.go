@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+func TestOAuth2_checkExpiry(t *testing.T) {
+	created := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	dat := &osin.AccessData{CreatedAt: created, ExpiresIn: 3600}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		leeway  time.Duration
+		wantErr error
+	}{
+		{
+			name: "valid",
+			now:  created.Add(30 * time.Minute),
+		},
+		{
+			name:    "expired",
+			now:     created.Add(61 * time.Minute),
+			wantErr: errors.Unauthorizedf("access token expired at 2026-01-01T11:00:00Z"),
+		},
+		{
+			name:   "expired, within leeway",
+			now:    created.Add(61 * time.Minute),
+			leeway: 2 * time.Minute,
+		},
+		{
+			name:    "expired, outside leeway",
+			now:     created.Add(65 * time.Minute),
+			leeway:  2 * time.Minute,
+			wantErr: errors.Unauthorizedf("access token expired at 2026-01-01T11:00:00Z"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := OAuth2(WithClock(func() time.Time { return tt.now }), WithLeeway(tt.leeway))
+
+			err := k.checkExpiry(dat)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("checkExpiry() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if tt.wantErr == nil {
+				return
+			}
+			if !errors.Is(err, ErrExpired) {
+				t.Errorf("checkExpiry() error %v is not %v", err, ErrExpired)
+			}
+			if ch := errors.Challenge(err); ch != invalidTokenChallenge {
+				t.Errorf("checkExpiry() challenge = %q, want %q", ch, invalidTokenChallenge)
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyAccessCodeResult_expired(t *testing.T) {
+	actor := mockActor()
+	access := mockAccess("test", defaultClient)
+
+	s := oauthVerifier{
+		st:    st(&actor, access),
+		l:     lw.Dev(lw.SetOutput(t.Output())),
+		clock: func() time.Time { return access.ExpireAt().Add(time.Second) },
+	}
+
+	got, err := s.VerifyAccessCodeResult("test")
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("VerifyAccessCodeResult() error = %v, want %v", err, ErrExpired)
+	}
+	if !cmp.Equal(got, anonymousResult(), EquateItems) {
+		t.Errorf("VerifyAccessCodeResult() got = %s", cmp.Diff(anonymousResult(), got, EquateItems))
+	}
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+// Introspection is the response of the RFC7662 token introspection endpoint.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	// Confirmation holds the keys the token is bound to.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+type introspectionHandler struct {
+	oauthVerifier
+}
+
+// IntrospectionHandler returns an http.Handler implementing the RFC7662 token introspection endpoint,
+// which allows our other services to validate the access tokens we issued, without needing access to the storage.
+//
+// The callers must authenticate with the credentials of a confidential OAuth2 client, so the storage needs
+// to be able to load the clients. Public clients, and access tokens, are not accepted, as anybody could
+// use them for finding out the details of the tokens.
+func IntrospectionHandler(initFns ...InitFn) http.Handler {
+	return introspectionHandler{oauthVerifier: OAuth2(initFns...)}
+}
+
+func (h introspectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "token introspection requires a POST request")
+		return
+	}
+	if err := h.authenticateCaller(r); err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, ErrMisconfigured) {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("WWW-Authenticate", introspectionChallenge)
+		writeOAuthError(w, status, "invalid_client", "unable to authenticate the caller")
+		return
+	}
+	tok := r.PostFormValue("token")
+	if tok == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing token parameter")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.introspect(tok))
+}
+
+// introspect returns the details of the tok access token.
+// NOTE(marius): as the RFC requires, we don't disclose why a token is inactive.
+func (h introspectionHandler) introspect(tok string) Introspection {
+	res, dat, err := h.verifyAccess(tok)
+	if err != nil {
+		return Introspection{Active: false}
+	}
+	in := Introspection{
+		Active:    true,
+		Scope:     strings.Join(res.Scopes, " "),
+		ClientID:  res.ClientID,
+		Subject:   res.Actor.ID.String(),
+		TokenType: "Bearer",
+		ExpiresAt: dat.ExpireAt().Unix(),
+		IssuedAt:  dat.CreatedAt.Unix(),
+	}
+	if cnf := res.confirmation(); !cnf.empty() {
+		in.Confirmation = &cnf
+	}
+	return in
+}
+
+// authenticateCaller checks the client credentials of the caller, which must be a confidential client.
+func (h introspectionHandler) authenticateCaller(r *http.Request) error {
+	if h.st == nil {
+		return errInvalidStorage
+	}
+	if _, ok := h.st.(clientStore); !ok {
+		return &VerificationError{Kind: ErrMisconfigured, Err: errors.Newf("storage can't load the OAuth2 clients")}
+	}
+	cl, err := h.authenticateClient(r)
+	if err != nil {
+		return err
+	}
+	if isPublicClient(cl) {
+		return classify(ErrBadSignature, "", "", errors.Unauthorizedf("client %s has no secret", cl.GetId()))
+	}
+	return nil
+}
+
+// introspectionChallenge is the WWW-Authenticate challenge for the callers that failed authentication.
+const introspectionChallenge = `Basic realm="token introspection"`
+
+// writeOAuthError writes the error response described in RFC6749 section 5.2.
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
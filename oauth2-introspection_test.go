@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+type mockClientStore struct {
+	mockStore
+	cl osin.Client
+}
+
+func (ms mockClientStore) GetClient(id string) (osin.Client, error) {
+	if ms.cl == nil || ms.cl.GetId() != id {
+		return nil, errors.NotFoundf("not found")
+	}
+	return ms.cl, nil
+}
+
+func mockIntrospectReq(method, token string, hh ...url.Values) *http.Request {
+	form := url.Values{}
+	if token != "" {
+		form.Set("token", token)
+	}
+	r := httptest.NewRequest(method, "http://example.com/oauth/introspect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, h := range hh {
+		for k, v := range h {
+			r.Header[k] = v
+		}
+	}
+	return r
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	actor := mockActor()
+	access := mockAccess("test", defaultClient)
+	active := Introspection{
+		Active:    true,
+		Scope:     "none",
+		ClientID:  "test-client",
+		Subject:   actor.ID.String(),
+		TokenType: "Bearer",
+		ExpiresAt: access.ExpireAt().Unix(),
+		IssuedAt:  access.CreatedAt.Unix(),
+	}
+	bearer := url.Values{"Authorization": []string{"Bearer test"}}
+	clients := mockClientStore{mockStore: st(&actor, access), cl: defaultClient}
+	public := mockClientStore{mockStore: st(&actor, access), cl: &osin.DefaultClient{Id: "public-client"}}
+	basic := func(id, secret string) url.Values {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.SetBasicAuth(id, secret)
+		return url.Values{"Authorization": r.Header.Values("Authorization")}
+	}
+
+	tests := []struct {
+		name       string
+		st         oauthStore
+		r          *http.Request
+		wantStatus int
+		want       any
+	}{
+		{
+			name:       "no storage",
+			r:          mockIntrospectReq(http.MethodPost, "test", bearer),
+			wantStatus: http.StatusInternalServerError,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "GET request",
+			st:         st(&actor, access),
+			r:          mockIntrospectReq(http.MethodGet, "test", bearer),
+			wantStatus: http.StatusMethodNotAllowed,
+			want:       map[string]string{"error": "invalid_request", "error_description": "token introspection requires a POST request"},
+		},
+		{
+			name:       "unauthenticated caller",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "test"),
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "missing token",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "", basic("test-client", "asd")),
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]string{"error": "invalid_request", "error_description": "missing token parameter"},
+		},
+		{
+			name:       "bearer caller",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "test", bearer),
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "basic caller, unsupported by storage",
+			st:         st(&actor, access),
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("test-client", "asd")),
+			wantStatus: http.StatusInternalServerError,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "basic caller, empty secret",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("test-client", "")),
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "public client",
+			st:         public,
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("public-client", "")),
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name: "public client, form credentials",
+			st:   public,
+			r: func() *http.Request {
+				form := url.Values{"token": {"test"}, "client_id": {"public-client"}}
+				r := httptest.NewRequest(http.MethodPost, "http://example.com/oauth/introspect", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			}(),
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "basic caller, invalid secret",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("test-client", "wrong")),
+			wantStatus: http.StatusUnauthorized,
+			want:       map[string]string{"error": "invalid_client", "error_description": "unable to authenticate the caller"},
+		},
+		{
+			name:       "basic caller, active token",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("test-client", "asd")),
+			wantStatus: http.StatusOK,
+			want:       active,
+		},
+		{
+			name:       "basic caller, unknown token",
+			st:         clients,
+			r:          mockIntrospectReq(http.MethodPost, "unknown", basic("test-client", "asd")),
+			wantStatus: http.StatusOK,
+			want:       Introspection{Active: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := IntrospectionHandler(WithStorage(tt.st))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("ServeHTTP() Content-Type = %q", ct)
+			}
+
+			var got any
+			switch tt.want.(type) {
+			case Introspection:
+				res := Introspection{}
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatalf("unable to decode response: %s", err)
+				}
+				got = res
+			default:
+				res := map[string]string{}
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatalf("unable to decode response: %s", err)
+				}
+				got = res
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("ServeHTTP() response = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
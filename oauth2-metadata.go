@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/openshift/osin"
+)
+
+// Endpoints holds the URLs where the OAuth2 handlers of the package are served.
+// They are advertised in the RFC8414 metadata document, and in the endpoints of the local actors.
+type Endpoints struct {
+	// Issuer is the identifier of the authorization server, when empty the JWT access tokens issuer is used.
+	Issuer        string
+	Authorization string
+	Token         string
+	Revocation    string
+	Introspection string
+	Registration  string
+	// JWKS is the URL of the document containing the keys the JWT access tokens are signed with.
+	JWKS string
+}
+
+// WithEndpoints sets the URLs of the OAuth2 endpoints, which are advertised by MetadataHandler.
+func WithEndpoints(e Endpoints) InitFn {
+	return func(c *config) {
+		c.endpoints = e
+	}
+}
+
+// LinkActor sets the OAuth2 authorization and token endpoints of the act actor, so C2S clients
+// can find them, as described in the ActivityPub specification section 4.1.1.
+func (e Endpoints) LinkActor(act *vocab.Actor) {
+	if act == nil || (e.Authorization == "" && e.Token == "") {
+		return
+	}
+	if act.Endpoints == nil {
+		act.Endpoints = &vocab.Endpoints{}
+	}
+	if e.Authorization != "" {
+		act.Endpoints.OauthAuthorizationEndpoint = vocab.IRI(e.Authorization)
+	}
+	if e.Token != "" {
+		act.Endpoints.OauthTokenEndpoint = vocab.IRI(e.Token)
+	}
+}
+
+// serverMetadata is the RFC8414 authorization server metadata document.
+type serverMetadata struct {
+	Issuer                                    string   `json:"issuer"`
+	AuthorizationEndpoint                     string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                             string   `json:"token_endpoint,omitempty"`
+	JWKSURI                                   string   `json:"jwks_uri,omitempty"`
+	RegistrationEndpoint                      string   `json:"registration_endpoint,omitempty"`
+	ScopesSupported                           []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	GrantTypesSupported                       []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint,omitempty"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint,omitempty"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported,omitempty"`
+	// DPoPSigningAlgValuesSupported is described in RFC9449 section 5.1.
+	DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported,omitempty"`
+	// TLSClientCertificateBoundAccessTokens is described in RFC8705 section 3.3.
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+	// ClientIDMetadataDocumentSupported is described in the OAuth Client ID Metadata Document draft.
+	ClientIDMetadataDocumentSupported bool `json:"client_id_metadata_document_supported,omitempty"`
+}
+
+// metadata returns the RFC8414 document describing the endpoints and the features of the handlers in this package.
+func (c config) metadata() serverMetadata {
+	e := c.endpoints
+	m := serverMetadata{
+		Issuer:                 e.Issuer,
+		AuthorizationEndpoint:  e.Authorization,
+		TokenEndpoint:          e.Token,
+		JWKSURI:                e.JWKS,
+		RegistrationEndpoint:   e.Registration,
+		RevocationEndpoint:     e.Revocation,
+		IntrospectionEndpoint:  e.Introspection,
+		ScopesSupported:        slices.Clone(supportedScopes),
+		ResponseTypesSupported: []string{string(osin.CODE)},
+		// NOTE(marius): these match the certificate and DPoP bindings checked by the verifier, which
+		// don't depend on any configuration.
+		DPoPSigningAlgValuesSupported:         strings.Fields(dpopAlgorithms),
+		TLSClientCertificateBoundAccessTokens: true,
+	}
+	if m.Issuer == "" && c.jwt != nil {
+		m.Issuer = c.jwt.issuer
+	}
+	clientAuth := []string{authMethodBasic, authMethodSecretPost}
+	if e.Authorization != "" || e.Token != "" {
+		m.GrantTypesSupported = []string{string(osin.AUTHORIZATION_CODE), string(osin.REFRESH_TOKEN), string(osin.CLIENT_CREDENTIALS)}
+		m.TokenEndpointAuthMethodsSupported = append(clientAuth, authMethodNone)
+		m.CodeChallengeMethodsSupported = []string{osin.PKCE_S256}
+	}
+	if c.clientDocs != nil {
+		m.ClientIDMetadataDocumentSupported = true
+	}
+	if e.Revocation != "" {
+		m.RevocationEndpointAuthMethodsSupported = clientAuth
+	}
+	if e.Introspection != "" {
+		m.IntrospectionEndpointAuthMethodsSupported = clientAuth
+	}
+	return m
+}
+
+type metadataHandler struct {
+	doc []byte
+}
+
+// MetadataHandler returns an http.Handler serving the RFC8414 authorization server metadata document, built
+// from the endpoints set with WithEndpoints and the same options the verifiers and the other handlers use.
+// It is meant to be served at "/.well-known/oauth-authorization-server", with the path of the issuer appended, if any.
+func MetadataHandler(initFns ...InitFn) http.Handler {
+	c := Config(initFns...)
+	m := c.metadata()
+	if m.Issuer == "" {
+		return metadataHandler{}
+	}
+	doc, _ := json.Marshal(m)
+	return metadataHandler{doc: doc}
+}
+
+func (h metadataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "the metadata document requires a GET request")
+		return
+	}
+	if h.doc == nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "the authorization server issuer is not configured")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(h.doc)
+	}
+}
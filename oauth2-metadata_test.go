@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+var mockEndpoints = Endpoints{
+	Issuer:        "https://example.com",
+	Authorization: "https://example.com/oauth/authorize",
+	Token:         "https://example.com/oauth/token",
+	Revocation:    "https://example.com/oauth/revoke",
+	Introspection: "https://example.com/oauth/introspect",
+	Registration:  "https://example.com/oauth/register",
+}
+
+func TestMetadataHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		initFns    []InitFn
+		method     string
+		wantStatus int
+		want       map[string]any
+	}{
+		{
+			name:       "not configured",
+			method:     http.MethodGet,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "wrong method",
+			initFns:    []InitFn{WithEndpoints(mockEndpoints)},
+			method:     http.MethodPost,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "JWT issuer only",
+			initFns:    []InitFn{WithJWTAccessTokens(jwks{}, "https://auth.example.com", "")},
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			want: map[string]any{
+				"issuer":                   "https://auth.example.com",
+				"scopes_supported":         []any{"read", "write"},
+				"response_types_supported": []any{"code"},
+				"tls_client_certificate_bound_access_tokens": true,
+			},
+		},
+		{
+			name:       "all endpoints",
+			initFns:    []InitFn{WithEndpoints(mockEndpoints)},
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			want: map[string]any{
+				"issuer":                                        "https://example.com",
+				"authorization_endpoint":                        "https://example.com/oauth/authorize",
+				"token_endpoint":                                "https://example.com/oauth/token",
+				"revocation_endpoint":                           "https://example.com/oauth/revoke",
+				"introspection_endpoint":                        "https://example.com/oauth/introspect",
+				"registration_endpoint":                         "https://example.com/oauth/register",
+				"scopes_supported":                              []any{"read", "write"},
+				"response_types_supported":                      []any{"code"},
+				"grant_types_supported":                         []any{"authorization_code", "refresh_token", "client_credentials"},
+				"token_endpoint_auth_methods_supported":         []any{"client_secret_basic", "client_secret_post", "none"},
+				"revocation_endpoint_auth_methods_supported":    []any{"client_secret_basic", "client_secret_post"},
+				"introspection_endpoint_auth_methods_supported": []any{"client_secret_basic", "client_secret_post"},
+				"code_challenge_methods_supported":              []any{"S256"},
+				"tls_client_certificate_bound_access_tokens":    true,
+			},
+		},
+		{
+			name:       "client metadata documents",
+			initFns:    []InitFn{WithEndpoints(Endpoints{Issuer: "https://example.com"}), WithClientMetadataDocuments(time.Hour)},
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			want: map[string]any{
+				"issuer":                   "https://example.com",
+				"scopes_supported":         []any{"read", "write"},
+				"response_types_supported": []any{"code"},
+				"tls_client_certificate_bound_access_tokens": true,
+				"client_id_metadata_document_supported":      true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "https://example.com/.well-known/oauth-authorization-server", nil)
+			MetadataHandler(tt.initFns...).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.want == nil {
+				return
+			}
+			got := make(map[string]any)
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("ServeHTTP() invalid document: %s", err)
+			}
+			if _, ok := got["dpop_signing_alg_values_supported"]; !ok {
+				t.Errorf("ServeHTTP() document is missing the DPoP algorithms")
+			}
+			delete(got, "dpop_signing_alg_values_supported")
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("ServeHTTP() document = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestEndpoints_LinkActor(t *testing.T) {
+	act := mockActor()
+	mockEndpoints.LinkActor(&act)
+	if act.Endpoints == nil {
+		t.Fatalf("LinkActor() didn't set the actor endpoints")
+	}
+	if act.Endpoints.OauthAuthorizationEndpoint != vocab.IRI(mockEndpoints.Authorization) {
+		t.Errorf("LinkActor() authorization endpoint = %v", act.Endpoints.OauthAuthorizationEndpoint)
+	}
+	if act.Endpoints.OauthTokenEndpoint != vocab.IRI(mockEndpoints.Token) {
+		t.Errorf("LinkActor() token endpoint = %v", act.Endpoints.OauthTokenEndpoint)
+	}
+
+	empty := mockActor()
+	Endpoints{}.LinkActor(&empty)
+	if empty.Endpoints != nil {
+		t.Errorf("LinkActor() without endpoints set the actor endpoints = %#v", empty.Endpoints)
+	}
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+var publicClient = &osin.DefaultClient{Id: "public-client", RedirectUri: "http://example.com"}
+
+const mockVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func mockChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func mockPKCEAuthorizeReq(clientID, challenge, method string) *http.Request {
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {"http://example.com"},
+		"state":         {"xyz"},
+	}
+	if challenge != "" {
+		q.Set("code_challenge", challenge)
+	}
+	if method != "" {
+		q.Set("code_challenge_method", method)
+	}
+	form := url.Values{"handle": {"jdoe"}, "pw": {"secret"}}
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/oauth/authorize?"+q.Encode(), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestAuthorizeHandler_PKCE(t *testing.T) {
+	actor := mockActor()
+
+	tests := []struct {
+		name      string
+		cl        osin.Client
+		r         *http.Request
+		wantError string
+	}{
+		{
+			name: "confidential client without PKCE",
+			cl:   defaultClient,
+			r:    mockPKCEAuthorizeReq("test-client", "", ""),
+		},
+		{
+			name:      "confidential client with plain PKCE",
+			cl:        defaultClient,
+			r:         mockPKCEAuthorizeReq("test-client", mockVerifier, osin.PKCE_PLAIN),
+			wantError: osin.E_INVALID_REQUEST,
+		},
+		{
+			name:      "public client without PKCE",
+			cl:        publicClient,
+			r:         mockPKCEAuthorizeReq("public-client", "", ""),
+			wantError: osin.E_INVALID_REQUEST,
+		},
+		{
+			name:      "public client with default PKCE method",
+			cl:        publicClient,
+			r:         mockPKCEAuthorizeReq("public-client", mockVerifier, ""),
+			wantError: osin.E_INVALID_REQUEST,
+		},
+		{
+			name: "public client with S256 PKCE",
+			cl:   publicClient,
+			r:    mockPKCEAuthorizeReq("public-client", mockChallenge(mockVerifier), osin.PKCE_S256),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := authStore(tt.cl, &actor)
+			h := AuthorizeHandler(
+				WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+				WithStorage(st),
+				WithAuthenticator(PasswordAuthenticator(st, mockIRIFn)),
+			)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.r)
+			w = consent(t, h, tt.r, w, consentApprove)
+			if w.Code != http.StatusFound {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, http.StatusFound, w.Body.String())
+			}
+			loc, _ := url.Parse(w.Header().Get("Location"))
+			if got := loc.Query().Get("error"); got != tt.wantError {
+				t.Errorf("ServeHTTP() redirect error = %q, want %q", got, tt.wantError)
+			}
+			if tt.wantError == "" && loc.Query().Get("code") == "" {
+				t.Errorf("ServeHTTP() redirect is missing the code: %s", loc)
+			}
+		})
+	}
+}
+
+func TestTokenHandler_PKCE(t *testing.T) {
+	actor := mockActor()
+	exchange := func(t *testing.T, verifier string) *httptest.ResponseRecorder {
+		st := authStore(publicClient, &actor)
+		_ = st.SaveAuthorize(&osin.AuthorizeData{
+			Client: publicClient, Code: "code-1", ExpiresIn: 300, RedirectUri: "http://example.com",
+			CreatedAt: time.Now(), UserData: actor.ID.String(),
+			CodeChallenge: mockChallenge(mockVerifier), CodeChallengeMethod: osin.PKCE_S256,
+		})
+		form := url.Values{
+			"grant_type": {"authorization_code"}, "code": {"code-1"}, "redirect_uri": {"http://example.com"},
+			"client_id": {"public-client"}, "code_verifier": {verifier},
+		}
+		w := httptest.NewRecorder()
+		TokenHandler(WithLogger(lw.Dev(lw.SetOutput(t.Output()))), WithStorage(st)).ServeHTTP(w, mockTokenEndpointReq(form))
+		return w
+	}
+
+	if w := exchange(t, mockVerifier); w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() with the code verifier status = %d: %s", w.Code, w.Body.String())
+	}
+	w := exchange(t, strings.Repeat("a", 43))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), osin.E_INVALID_GRANT) {
+		t.Errorf("ServeHTTP() with a wrong code verifier status = %d: %s", w.Code, w.Body.String())
+	}
+
+	st := authStore(publicClient, &actor)
+	w = httptest.NewRecorder()
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"public-client"}}
+	TokenHandler(WithStorage(st)).ServeHTTP(w, mockTokenEndpointReq(form))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), osin.E_ACCESS_DENIED) {
+		t.Errorf("ServeHTTP() client_credentials for a public client status = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func Test_oauthVerifier_checkPKCE(t *testing.T) {
+	s256 := &osin.AuthorizeData{CodeChallenge: mockChallenge(mockVerifier), CodeChallengeMethod: osin.PKCE_S256}
+	plain := &osin.AuthorizeData{CodeChallenge: mockVerifier, CodeChallengeMethod: osin.PKCE_PLAIN}
+	app := &osin.DefaultClient{Id: "app", Secret: "dsa", RedirectUri: "http://example.com", UserData: "http://example.com/~app"}
+
+	tests := []struct {
+		name     string
+		required bool
+		at       accessToken
+		wantErr  bool
+	}{
+		{
+			name: "not required",
+			at:   accessToken{AccessData: &osin.AccessData{Client: publicClient}},
+		},
+		{
+			name:     "S256 code",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: publicClient, AuthorizeData: s256}},
+		},
+		{
+			name:     "refreshed S256 code",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: publicClient, AccessData: &osin.AccessData{AuthorizeData: s256}}},
+		},
+		{
+			name:     "plain code",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: defaultClient, AuthorizeData: plain}},
+			wantErr:  true,
+		},
+		{
+			name:     "code without PKCE",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: defaultClient, AuthorizeData: &osin.AuthorizeData{}}},
+			wantErr:  true,
+		},
+		{
+			name:     "no code, confidential client",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: defaultClient}},
+			wantErr:  true,
+		},
+		{
+			name:     "client_credentials",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: app, UserData: "http://example.com/~app"}},
+		},
+		{
+			name:     "no code, confidential client, for another actor",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: app, UserData: "http://example.com/~jdoe"}},
+			wantErr:  true,
+		},
+		{
+			name:     "refreshed token without code",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: app, UserData: "http://example.com/~app", AccessData: &osin.AccessData{}}},
+			wantErr:  true,
+		},
+		{
+			name:     "no code, public client",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{Client: publicClient}},
+			wantErr:  true,
+		},
+		{
+			name:     "self-contained token",
+			required: true,
+			at:       accessToken{AccessData: &osin.AccessData{}, offline: true},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fns := []InitFn{}
+			if tt.required {
+				fns = append(fns, WithRequiredPKCE())
+			}
+			err := OAuth2(fns...).checkPKCE(tt.at)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPKCE() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrPolicy) {
+				t.Errorf("checkPKCE() error = %v, want %v", err, ErrPolicy)
+			}
+		})
+	}
+}
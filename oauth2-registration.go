@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+// registrationStore is the OAuth2 storage that can save new clients.
+// The method matches the one of the go-ap storage backends.
+type registrationStore interface {
+	clientStore
+	CreateClient(osin.Client) error
+}
+
+// redirectURISeparator separates the redirect URIs of the clients that registered more than one.
+const redirectURISeparator = "\n"
+
+const (
+	authMethodNone       = "none"
+	authMethodSecretPost = "client_secret_post"
+	authMethodBasic      = "client_secret_basic"
+)
+
+// ClientRegistration holds the metadata of a dynamically registered OAuth2 client, as described in RFC7591 section 2.
+// It is saved as the UserData of the client.
+type ClientRegistration struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	SoftwareID              string   `json:"software_id,omitempty"`
+	SoftwareVersion         string   `json:"software_version,omitempty"`
+	SoftwareStatement       string   `json:"software_statement,omitempty"`
+}
+
+// registrationResponse is the RFC7591 section 3.2.1 response for a successful registration.
+type registrationResponse struct {
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt *int64 `json:"client_secret_expires_at,omitempty"`
+	ClientRegistration
+}
+
+// softwareStatements verifies the signed software statements of the registration requests.
+type softwareStatements struct {
+	keys   KeySet
+	issuer string
+}
+
+// WithSoftwareStatements enables the RFC7591 software statements, which are JWTs signed with one of the keys of ks
+// by the issuer, and which carry the client metadata vouched for by the software publisher.
+// Without it, the software statements of the registration requests are ignored, and they are not saved
+// with the client metadata.
+func WithSoftwareStatements(ks KeySet, issuer string) InitFn {
+	return func(c *config) {
+		c.statements = &softwareStatements{keys: ks, issuer: issuer}
+	}
+}
+
+const (
+	defaultRegistrationLimit  = 10
+	defaultRegistrationPeriod = time.Hour
+)
+
+// WithRegistrationLimit limits the number of clients that can be registered from the same address to n per period.
+// By default, ten clients per hour can be registered from an address, a n value of zero removes the limit.
+func WithRegistrationLimit(n int, period time.Duration) InitFn {
+	return func(c *config) {
+		c.regLimit = newRateLimiter(n, period)
+	}
+}
+
+type registrationHandler struct {
+	oauthVerifier
+	statements *softwareStatements
+	limit      *rateLimiter
+}
+
+// RegistrationHandler returns an http.Handler implementing the RFC7591 dynamic client registration endpoint,
+// which allows the ActivityPub C2S applications to register themselves as OAuth2 clients.
+//
+// The storage must be able to create clients, otherwise all requests fail.
+// The clients that register with the "none" authentication method are public clients, which must use PKCE.
+func RegistrationHandler(initFns ...InitFn) http.Handler {
+	c := Config(initFns...)
+	h := registrationHandler{oauthVerifier: newOAuthVerifier(c), statements: c.statements, limit: c.regLimit}
+	if h.limit == nil {
+		h.limit = newRateLimiter(defaultRegistrationLimit, defaultRegistrationPeriod)
+	}
+	return h
+}
+
+func (h registrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "client registration requires a POST request")
+		return
+	}
+	st, ok := h.st.(registrationStore)
+	if !ok {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "client registration is not supported")
+		return
+	}
+	if wait, ok := h.limit.allow(clientAddr(r, h.proxies, h.forwarded), h.now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeOAuthError(w, http.StatusTooManyRequests, "invalid_request", "too many client registrations")
+		return
+	}
+
+	meta := ClientRegistration{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&meta); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client_metadata", "unable to parse client metadata")
+		return
+	}
+	if h.statements == nil {
+		// NOTE(marius): the statement can't be verified, so we don't keep it, as the clients loading
+		// the metadata could assume it was.
+		meta.SoftwareStatement = ""
+	}
+	if stmt := meta.SoftwareStatement; stmt != "" {
+		claims, err := h.statements.verify(stmt, h.now())
+		if err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_software_statement", err.Error())
+			return
+		}
+		// NOTE(marius): the values in the software statement take precedence over the ones in the request,
+		// as required by RFC7591 section 2.3, unmarshalling the claims overwrites only the fields they contain.
+		_ = json.Unmarshal(claims, &meta)
+		meta.SoftwareStatement = stmt
+	}
+	if code, err := meta.validate(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, code, err.Error())
+		return
+	}
+
+	res := registrationResponse{
+		ClientID:           randomToken(16),
+		ClientIDIssuedAt:   h.now().Unix(),
+		ClientRegistration: meta,
+	}
+	if meta.TokenEndpointAuthMethod != authMethodNone {
+		never := int64(0)
+		res.ClientSecret, res.ClientSecretExpiresAt = randomToken(32), &never
+	}
+	cl := &osin.DefaultClient{
+		Id:          res.ClientID,
+		Secret:      res.ClientSecret,
+		RedirectUri: strings.Join(meta.RedirectURIs, redirectURISeparator),
+		UserData:    meta,
+	}
+	if err := st.CreateClient(cl); err != nil {
+		h.l.WithContext(lw.Ctx{"err": err.Error()}).Errorf("unable to save client")
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "unable to save client")
+		return
+	}
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// validate checks the client metadata, setting the default values described in RFC7591 section 2.
+// It returns the RFC7591 error code together with the error.
+func (m *ClientRegistration) validate() (string, error) {
+	if len(m.RedirectURIs) == 0 {
+		return "invalid_redirect_uri", errors.Newf("at least one redirect URI is required")
+	}
+	for _, u := range m.RedirectURIs {
+		if err := validateRedirectURI(u); err != nil {
+			return "invalid_redirect_uri", err
+		}
+	}
+	if m.TokenEndpointAuthMethod == "" {
+		m.TokenEndpointAuthMethod = authMethodBasic
+	}
+	if !slices.Contains([]string{authMethodNone, authMethodSecretPost, authMethodBasic}, m.TokenEndpointAuthMethod) {
+		return "invalid_client_metadata", errors.Newf("unsupported token_endpoint_auth_method %q", m.TokenEndpointAuthMethod)
+	}
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{string(osin.AUTHORIZATION_CODE)}
+	}
+	for _, g := range m.GrantTypes {
+		// NOTE(marius): the client_credentials grant needs an actor for the client, which can't be registered this way.
+		if g != string(osin.AUTHORIZATION_CODE) && g != string(osin.REFRESH_TOKEN) {
+			return "invalid_client_metadata", errors.Newf("unsupported grant type %q", g)
+		}
+	}
+	if len(m.ResponseTypes) == 0 {
+		m.ResponseTypes = []string{string(osin.CODE)}
+	}
+	for _, rt := range m.ResponseTypes {
+		if rt != string(osin.CODE) {
+			return "invalid_client_metadata", errors.Newf("unsupported response type %q", rt)
+		}
+	}
+	for _, u := range []string{m.ClientURI, m.LogoURI} {
+		if u == "" {
+			continue
+		}
+		if pu, err := url.Parse(u); err != nil || (pu.Scheme != "https" && pu.Scheme != "http") || pu.Host == "" {
+			return "invalid_client_metadata", errors.Newf("invalid URI %q", u)
+		}
+	}
+	return "", nil
+}
+
+// validateRedirectURI checks that the redirect URI can be safely used, as recommended by RFC6749 section 3.1.2 and RFC8252:
+// it must be absolute, without a fragment, and either use https, http on the loopback interface, or
+// a private-use scheme, in reverse domain name notation, for native applications.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return errors.Newf("redirect URI %q must be an absolute URI", raw)
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return errors.Newf("redirect URI %q must not contain a fragment", raw)
+	}
+	if strings.Contains(raw, redirectURISeparator) {
+		return errors.Newf("redirect URI %q is invalid", raw)
+	}
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return errors.Newf("redirect URI %q is missing the host", raw)
+		}
+		return nil
+	case "http":
+		if ip := net.ParseIP(u.Hostname()); (ip != nil && ip.IsLoopback()) || u.Hostname() == "localhost" {
+			return nil
+		}
+		return errors.Newf("redirect URI %q must use https", raw)
+	}
+	if strings.Contains(u.Scheme, ".") {
+		return nil
+	}
+	return errors.Newf("redirect URI %q uses an unsupported scheme", raw)
+}
+
+// verify checks the signature, issuer and expiry of the stmt software statement, and returns its claims.
+func (s *softwareStatements) verify(stmt string, now time.Time) ([]byte, error) {
+	if !isJWT(stmt) {
+		return nil, errors.Newf("the software statement is not a JWT")
+	}
+	parts := strings.Split(stmt, ".")
+	header := jwtHeader{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errors.Annotatef(err, "invalid software statement header")
+	}
+	pub, err := s.keys.Key(header.Kid)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unknown software statement key")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid software statement signature encoding")
+	}
+	if err = verifyJWS(header.Alg, pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, errors.Annotatef(err, "invalid software statement signature")
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid software statement claims")
+	}
+	std := struct {
+		Issuer    string `json:"iss"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+	}{}
+	if err = json.Unmarshal(claims, &std); err != nil {
+		return nil, errors.Annotatef(err, "invalid software statement claims")
+	}
+	if std.Issuer != s.issuer {
+		return nil, errors.Newf("software statement issued by %q", std.Issuer)
+	}
+	if std.ExpiresAt != 0 && now.After(time.Unix(std.ExpiresAt, 0)) {
+		return nil, errors.Newf("software statement expired")
+	}
+	return claims, nil
+}
+
+// randomToken returns a random URL-safe string, encoding n bytes.
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// rateLimiter is a fixed-window limiter, counting the events for each key.
+type rateLimiter struct {
+	n      int
+	period time.Duration
+
+	m       sync.Mutex
+	windows map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(n int, period time.Duration) *rateLimiter {
+	return &rateLimiter{n: n, period: period, windows: make(map[string]rateWindow)}
+}
+
+// allow counts an event for key, and returns false, together with the time left until the
+// window resets, when the limit was reached.
+func (l *rateLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	if l == nil || l.n <= 0 {
+		return 0, true
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for k, win := range l.windows {
+		if now.Sub(win.start) >= l.period {
+			delete(l.windows, k)
+		}
+	}
+	win, ok := l.windows[key]
+	if !ok {
+		win = rateWindow{start: now}
+	}
+	if win.count >= l.n {
+		return l.period - now.Sub(win.start), false
+	}
+	win.count++
+	l.windows[key] = win
+	return 0, true
+}
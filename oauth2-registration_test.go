@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+type mockRegStore struct {
+	*mockAuthStore
+	clients map[string]osin.Client
+}
+
+func regStore(el ...any) *mockRegStore {
+	return &mockRegStore{mockAuthStore: authStore(nil, el...), clients: make(map[string]osin.Client)}
+}
+
+func (ms *mockRegStore) GetClient(id string) (osin.Client, error) {
+	if cl, ok := ms.clients[id]; ok {
+		return cl, nil
+	}
+	return nil, errors.NotFoundf("not found")
+}
+
+func (ms *mockRegStore) CreateClient(cl osin.Client) error {
+	ms.clients[cl.GetId()] = cl
+	return nil
+}
+
+func mockRegistrationReq(meta any) *http.Request {
+	data, _ := json.Marshal(meta)
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/oauth/register", bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func register(t *testing.T, h http.Handler, meta any) (*httptest.ResponseRecorder, map[string]any) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, mockRegistrationReq(meta))
+	res := make(map[string]any)
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("ServeHTTP() invalid response: %s", err)
+	}
+	return w, res
+}
+
+func TestRegistrationHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		meta       any
+		wantStatus int
+		wantError  string
+		wantSecret bool
+	}{
+		{
+			name:       "confidential client",
+			meta:       ClientRegistration{RedirectURIs: []string{"https://app.example.com/callback"}, ClientName: "App"},
+			wantStatus: http.StatusCreated,
+			wantSecret: true,
+		},
+		{
+			name: "public native client",
+			meta: ClientRegistration{
+				RedirectURIs:            []string{"com.example.app:/callback", "http://127.0.0.1:8080/callback"},
+				TokenEndpointAuthMethod: "none",
+			},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "invalid JSON",
+			meta:       "redirect_uris",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_client_metadata",
+		},
+		{
+			name:       "missing redirect URIs",
+			meta:       ClientRegistration{ClientName: "App"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_redirect_uri",
+		},
+		{
+			name:       "plain http redirect URI",
+			meta:       ClientRegistration{RedirectURIs: []string{"http://app.example.com/callback"}},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_redirect_uri",
+		},
+		{
+			name:       "client_credentials grant",
+			meta:       ClientRegistration{RedirectURIs: []string{"https://app.example.com/callback"}, GrantTypes: []string{"client_credentials"}},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_client_metadata",
+		},
+		{
+			name:       "unsupported auth method",
+			meta:       ClientRegistration{RedirectURIs: []string{"https://app.example.com/callback"}, TokenEndpointAuthMethod: "private_key_jwt"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_client_metadata",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := regStore()
+			h := RegistrationHandler(WithLogger(lw.Dev(lw.SetOutput(t.Output()))), WithStorage(st))
+
+			w, res := register(t, h, tt.meta)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantError != "" {
+				if res["error"] != tt.wantError {
+					t.Errorf("ServeHTTP() error = %v, want %s", res["error"], tt.wantError)
+				}
+				return
+			}
+			id, _ := res["client_id"].(string)
+			cl, err := st.GetClient(id)
+			if err != nil {
+				t.Fatalf("ServeHTTP() client %q was not saved: %s", id, err)
+			}
+			secret, _ := res["client_secret"].(string)
+			if (secret != "") != tt.wantSecret {
+				t.Errorf("ServeHTTP() client secret = %q, want secret %t", secret, tt.wantSecret)
+			}
+			if !osin.CheckClientSecret(cl, secret) {
+				t.Errorf("ServeHTTP() the saved client doesn't match the returned secret")
+			}
+		})
+	}
+}
+
+func TestRegistrationHandler_limit(t *testing.T) {
+	h := RegistrationHandler(WithStorage(regStore()), WithRegistrationLimit(2, time.Hour))
+	meta := ClientRegistration{RedirectURIs: []string{"https://app.example.com/callback"}}
+
+	for i := 0; i < 2; i++ {
+		if w, _ := register(t, h, meta); w.Code != http.StatusCreated {
+			t.Fatalf("ServeHTTP() registration %d status = %d", i, w.Code)
+		}
+	}
+	w, _ := register(t, h, meta)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() over the limit status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("ServeHTTP() over the limit is missing the Retry-After header")
+	}
+}
+
+func TestRegistrationHandler_defaultLimit(t *testing.T) {
+	h := RegistrationHandler(WithStorage(regStore()))
+	meta := ClientRegistration{RedirectURIs: []string{"https://app.example.com/callback"}}
+
+	for i := 0; i < defaultRegistrationLimit; i++ {
+		if w, _ := register(t, h, meta); w.Code != http.StatusCreated {
+			t.Fatalf("ServeHTTP() registration %d status = %d", i, w.Code)
+		}
+	}
+	if w, _ := register(t, h, meta); w.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() over the default limit status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	unlimited := RegistrationHandler(WithStorage(regStore()), WithRegistrationLimit(0, 0))
+	for i := 0; i <= defaultRegistrationLimit; i++ {
+		if w, _ := register(t, unlimited, meta); w.Code != http.StatusCreated {
+			t.Fatalf("ServeHTTP() without limit, registration %d status = %d", i, w.Code)
+		}
+	}
+}
+
+func TestRegistrationHandler_softwareStatement(t *testing.T) {
+	now := time.Now()
+	keys := jwks{"publisher": prvKeyEd25519.Public()}
+	statement := func(issuer string) string {
+		claims := map[string]any{"iss": issuer, "iat": now.Unix(), "client_name": "Vouched App", "software_id": "app-1"}
+		return signJWS(t, "EdDSA", prvKeyEd25519, jwtHeader{Alg: "EdDSA", Kid: "publisher", Typ: "JWT"}, claims)
+	}
+	meta := func(stmt string) ClientRegistration {
+		return ClientRegistration{RedirectURIs: []string{"https://app.example.com/callback"}, ClientName: "App", SoftwareStatement: stmt}
+	}
+
+	h := RegistrationHandler(WithStorage(regStore()), WithSoftwareStatements(keys, "https://publisher.example.com"))
+	w, res := register(t, h, meta(statement("https://publisher.example.com")))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("ServeHTTP() status = %d: %s", w.Code, w.Body.String())
+	}
+	if res["client_name"] != "Vouched App" || res["software_id"] != "app-1" {
+		t.Errorf("ServeHTTP() the software statement didn't take precedence: %v", res)
+	}
+
+	if w, res = register(t, h, meta(statement("https://evil.example.com"))); res["error"] != "invalid_software_statement" {
+		t.Errorf("ServeHTTP() for another issuer status = %d, error = %v", w.Code, res["error"])
+	}
+	tampered := tamperJWT(statement("https://publisher.example.com"), jwtClaims{Issuer: "https://publisher.example.com"})
+	if w, res = register(t, h, meta(tampered)); res["error"] != "invalid_software_statement" {
+		t.Errorf("ServeHTTP() for a tampered statement status = %d, error = %v", w.Code, res["error"])
+	}
+
+	ignored := RegistrationHandler(WithStorage(regStore()))
+	if w, res = register(t, ignored, meta(statement("https://evil.example.com"))); w.Code != http.StatusCreated || res["client_name"] != "App" {
+		t.Errorf("ServeHTTP() without statement verification status = %d: %v", w.Code, res)
+	}
+	if res["software_statement"] != nil {
+		t.Errorf("ServeHTTP() without statement verification kept the statement: %v", res["software_statement"])
+	}
+}
+
+func TestRegistrationHandler_authorizationFlow(t *testing.T) {
+	actor := mockActor()
+	st := regStore(&actor)
+	fns := []InitFn{
+		WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+		WithStorage(st),
+		WithAuthenticator(PasswordAuthenticator(st, mockIRIFn)),
+	}
+
+	_, res := register(t, RegistrationHandler(fns...), ClientRegistration{
+		RedirectURIs:            []string{"com.example.app:/callback"},
+		TokenEndpointAuthMethod: "none",
+		ClientName:              "App",
+	})
+	id, _ := res["client_id"].(string)
+
+	r := mockPKCEAuthorizeReq(id, mockChallenge(mockVerifier), osin.PKCE_S256)
+	q := r.URL.Query()
+	q.Set("redirect_uri", "com.example.app:/callback")
+	r.URL.RawQuery = q.Encode()
+	h := AuthorizeHandler(fns...)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	w = consent(t, h, r, w, consentApprove)
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	code := loc.Query().Get("code")
+	if code == "" {
+		t.Fatalf("authorization failed: %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	form := url.Values{
+		"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {"com.example.app:/callback"},
+		"client_id": {id}, "code_verifier": {mockVerifier},
+	}
+	w = httptest.NewRecorder()
+	TokenHandler(fns...).ServeHTTP(w, mockTokenEndpointReq(form))
+	tok := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&tok); err != nil || tok.AccessToken == "" {
+		t.Fatalf("token exchange failed: %d %s", w.Code, w.Body.String())
+	}
+
+	got, err := OAuth2(append(fns, WithRequiredPKCE())...).VerifyAccessCodeResult(tok.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyAccessCodeResult() error = %v", err)
+	}
+	if got.ClientID != id || got.Actor.ID != actor.ID {
+		t.Errorf("VerifyAccessCodeResult() client = %s, actor = %s", got.ClientID, got.Actor.ID)
+	}
+	if meta, ok := got.ClientMetadata.(ClientRegistration); !ok || meta.ClientName != "App" {
+		t.Errorf("VerifyAccessCodeResult() client metadata = %#v", got.ClientMetadata)
+	}
+}
+
+func Test_validateRedirectURI(t *testing.T) {
+	tests := map[string]bool{
+		"https://app.example.com/callback":      true,
+		"http://127.0.0.1:8080/callback":        true,
+		"http://[::1]/callback":                 true,
+		"http://localhost/callback":             true,
+		"com.example.app:/callback":             true,
+		"http://app.example.com/callback":       false,
+		"https://app.example.com/callback#frag": false,
+		"/callback":                             false,
+		"javascript:alert(1)":                   false,
+		"data:text/html,hello":                  false,
+		"https:///callback":                     false,
+	}
+	for uri, valid := range tests {
+		if err := validateRedirectURI(uri); (err == nil) != valid {
+			t.Errorf("validateRedirectURI(%q) error = %v, want valid %t", uri, err, valid)
+		}
+	}
+}
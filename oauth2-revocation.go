@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"net/http"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+// revocationStore is the OAuth2 storage that can also remove tokens.
+// The method names match the osin.Storage ones, so the storage backends used for the authorization server
+// implement it.
+type revocationStore interface {
+	oauthStore
+	clientStore
+	LoadRefresh(token string) (*osin.AccessData, error)
+	RemoveAccess(token string) error
+	RemoveRefresh(token string) error
+}
+
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+type revocationHandler struct {
+	oauthVerifier
+}
+
+// RevocationHandler returns an http.Handler implementing the RFC7009 token revocation endpoint,
+// which allows clients to invalidate their access and refresh tokens, like when the user logs out.
+//
+// The storage must be able to load clients and remove tokens, otherwise all requests fail.
+// The JWT access tokens enabled with WithJWTAccessTokens can't be revoked, so they are rejected
+// with the unsupported_token_type error.
+func RevocationHandler(initFns ...InitFn) http.Handler {
+	return revocationHandler{oauthVerifier: OAuth2(initFns...)}
+}
+
+func (h revocationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "token revocation requires a POST request")
+		return
+	}
+	st, ok := h.st.(revocationStore)
+	if !ok {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "token revocation is not supported")
+		return
+	}
+	cl, err := h.authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token revocation"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "unable to authenticate the client")
+		return
+	}
+	tok := r.PostFormValue("token")
+	if tok == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing token parameter")
+		return
+	}
+
+	if h.jwt != nil && isJWT(tok) {
+		// NOTE(marius): the JWTs are verified offline, so removing them from the storage would have no effect.
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_token_type", "JWT access tokens can't be revoked")
+		return
+	}
+
+	if err = revoke(st, cl, tok, r.PostFormValue("token_type_hint")); err != nil {
+		if errors.IsForbidden(err) {
+			writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "the token was not issued to the client")
+			return
+		}
+		h.l.WithContext(lw.Ctx{"err": err.Error()}).Errorf("unable to revoke token")
+		writeOAuthError(w, http.StatusServiceUnavailable, "server_error", "unable to revoke token")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// revoke removes the tok token, and the tokens issued together with it, from the storage.
+// The hint is used only for deciding which kind of token to look for first.
+// NOTE(marius): as the RFC requires, unknown tokens are not considered an error, as the client can't do anything about them.
+func revoke(st revocationStore, cl osin.Client, tok, hint string) error {
+	lookups := []func(string) (*osin.AccessData, error){st.LoadAccess, st.LoadRefresh}
+	if hint == tokenTypeRefresh {
+		lookups = []func(string) (*osin.AccessData, error){st.LoadRefresh, st.LoadAccess}
+	}
+
+	var dat *osin.AccessData
+	for _, load := range lookups {
+		if d, err := load(tok); err == nil && d != nil {
+			dat = d
+			break
+		}
+	}
+	if dat == nil {
+		return nil
+	}
+	if dat.Client == nil || dat.Client.GetId() != cl.GetId() {
+		return errors.Forbiddenf("token was issued to a different client")
+	}
+
+	if dat.AccessToken != "" {
+		if err := st.RemoveAccess(dat.AccessToken); err != nil && !errors.IsNotFound(err) {
+			return errors.Annotatef(err, "unable to remove access token")
+		}
+	}
+	if dat.RefreshToken != "" {
+		if err := st.RemoveRefresh(dat.RefreshToken); err != nil && !errors.IsNotFound(err) {
+			return errors.Annotatef(err, "unable to remove refresh token")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+type mockTokenStore struct {
+	mockClientStore
+	access  map[string]*osin.AccessData
+	refresh map[string]*osin.AccessData
+}
+
+func tokenStore(cl osin.Client, el ...any) *mockTokenStore {
+	s := mockTokenStore{
+		mockClientStore: mockClientStore{mockStore: st(el...), cl: cl},
+		access:          make(map[string]*osin.AccessData),
+		refresh:         make(map[string]*osin.AccessData),
+	}
+	for _, in := range el {
+		if ac, ok := in.(osin.AccessData); ok {
+			s.access[ac.AccessToken] = &ac
+			if ac.RefreshToken != "" {
+				s.refresh[ac.RefreshToken] = &ac
+			}
+		}
+	}
+	return &s
+}
+
+func (ms *mockTokenStore) LoadAccess(tok string) (*osin.AccessData, error) {
+	if ac, ok := ms.access[tok]; ok {
+		return ac, nil
+	}
+	return nil, errors.NotFoundf("not found")
+}
+
+func (ms *mockTokenStore) LoadRefresh(tok string) (*osin.AccessData, error) {
+	if ac, ok := ms.refresh[tok]; ok {
+		return ac, nil
+	}
+	return nil, errors.NotFoundf("not found")
+}
+
+func (ms *mockTokenStore) RemoveAccess(tok string) error {
+	delete(ms.access, tok)
+	return nil
+}
+
+func (ms *mockTokenStore) RemoveRefresh(tok string) error {
+	delete(ms.refresh, tok)
+	return nil
+}
+
+func TestRevocationHandler(t *testing.T) {
+	actor := mockActor()
+	otherClient := &osin.DefaultClient{Id: "other-client", Secret: "dsa"}
+	basic := func(id, secret string) url.Values {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.SetBasicAuth(id, secret)
+		return url.Values{"Authorization": r.Header.Values("Authorization")}
+	}
+
+	tests := []struct {
+		name        string
+		st          oauthStore
+		r           *http.Request
+		wantStatus  int
+		wantRevoked bool
+	}{
+		{
+			name:       "storage can't remove tokens",
+			st:         mockClientStore{mockStore: st(&actor, mockAccess("test", defaultClient)), cl: defaultClient},
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("test-client", "asd")),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "GET request",
+			st:         tokenStore(defaultClient, &actor, mockAccess("test", defaultClient)),
+			r:          mockIntrospectReq(http.MethodGet, "test", basic("test-client", "asd")),
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "unauthenticated client",
+			st:         tokenStore(defaultClient, &actor, mockAccess("test", defaultClient)),
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("test-client", "wrong")),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing token",
+			st:         tokenStore(defaultClient, &actor, mockAccess("test", defaultClient)),
+			r:          mockIntrospectReq(http.MethodPost, "", basic("test-client", "asd")),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown token",
+			st:         tokenStore(defaultClient, &actor, mockAccess("test", defaultClient)),
+			r:          mockIntrospectReq(http.MethodPost, "unknown", basic("test-client", "asd")),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token of other client",
+			st:         tokenStore(otherClient, &actor, mockAccess("test", defaultClient)),
+			r:          mockIntrospectReq(http.MethodPost, "test", basic("other-client", "dsa")),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "access token",
+			st:          tokenStore(defaultClient, &actor, mockAccess("test", defaultClient)),
+			r:           mockIntrospectReq(http.MethodPost, "test", basic("test-client", "asd")),
+			wantStatus:  http.StatusOK,
+			wantRevoked: true,
+		},
+		{
+			name: "refresh token, with form credentials",
+			st:   tokenStore(defaultClient, &actor, mockAccess("test", defaultClient)),
+			r: func() *http.Request {
+				r := mockIntrospectReq(http.MethodPost, "refresh-666")
+				r.PostForm = url.Values{
+					"token":           {"refresh-666"},
+					"token_type_hint": {tokenTypeRefresh},
+					"client_id":       {"test-client"},
+					"client_secret":   {"asd"},
+				}
+				return r
+			}(),
+			wantStatus:  http.StatusOK,
+			wantRevoked: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			RevocationHandler(WithStorage(tt.st)).ServeHTTP(w, tt.r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			_, err := OAuth2(WithStorage(tt.st)).VerifyAccessCode("test")
+			if revoked := err != nil; revoked != tt.wantRevoked {
+				t.Errorf("VerifyAccessCode() after revocation error = %v, revoked %t, want %t", err, revoked, tt.wantRevoked)
+			}
+			if ts, ok := tt.st.(*mockTokenStore); ok && tt.wantRevoked {
+				if _, err := ts.LoadRefresh("refresh-666"); err == nil {
+					t.Errorf("refresh token was not revoked")
+				}
+			}
+		})
+	}
+}
+
+func TestRevocationHandler_JWT(t *testing.T) {
+	actor := mockActor()
+	basic := httptest.NewRequest(http.MethodPost, "/", nil)
+	basic.SetBasicAuth("test-client", "asd")
+	r := mockIntrospectReq(http.MethodPost, "header.claims.signature", url.Values{"Authorization": basic.Header.Values("Authorization")})
+
+	w := httptest.NewRecorder()
+	RevocationHandler(
+		WithStorage(tokenStore(defaultClient, &actor)),
+		WithJWTAccessTokens(jwks{}, "https://example.com", ""),
+	).ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), `"unsupported_token_type"`) {
+		t.Errorf("ServeHTTP() response = %s, want unsupported_token_type", w.Body.String())
+	}
+}
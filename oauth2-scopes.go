@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+// ScopesFn returns the OAuth2 scopes that an access token needs to have been granted for authorizing the request.
+type ScopesFn func(*http.Request) []string
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// supportedScopes are the scopes that the authorization server can grant.
+var supportedScopes = []string{ScopeRead, ScopeWrite}
+
+// WithRequiredScopes sets the function that determines the scopes required for authorizing a request
+// using an OAuth2 access token.
+func WithRequiredScopes(fn ScopesFn) InitFn {
+	return func(c *config) {
+		c.scopesFn = fn
+	}
+}
+
+// MethodScopes requires the "read" scope for safe HTTP methods, and the "write" scope for all others.
+func MethodScopes(r *http.Request) []string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return []string{ScopeRead}
+	}
+	return []string{ScopeWrite}
+}
+
+// HasScopes checks if all the scopes have been granted to the access token that authorized the request.
+func (r VerificationResult) HasScopes(scopes ...string) bool {
+	return len(missingScopes(r.Scopes, scopes)) == 0
+}
+
+func missingScopes(granted, required []string) []string {
+	var missing []string
+	for _, s := range required {
+		if !slices.Contains(granted, s) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// clampScopes removes the scopes we can't grant from the space separated list of requested scopes.
+// It fails only when none of the requested scopes can be granted.
+func clampScopes(requested string) (string, bool) {
+	var granted []string
+	for _, s := range strings.Fields(requested) {
+		if slices.Contains(supportedScopes, s) && !slices.Contains(granted, s) {
+			granted = append(granted, s)
+		}
+	}
+	return strings.Join(granted, " "), len(granted) > 0 || strings.TrimSpace(requested) == ""
+}
+
+// insufficientScopeChallenge builds the WWW-Authenticate challenge described in RFC6750 for tokens
+// that lack the required scopes.
+func insufficientScopeChallenge(required []string) string {
+	return fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(required, " "))
+}
+
+// checkScopes returns an error if the access token wasn't granted the scopes required by the request.
+func (k oauthVerifier) checkScopes(r *http.Request, res VerificationResult) error {
+	if k.scopesFn == nil {
+		return nil
+	}
+	required := k.scopesFn(r)
+	missing := missingScopes(res.Scopes, required)
+	if len(missing) == 0 {
+		return nil
+	}
+	err := errors.Forbiddenf("access token is missing the required scopes: %s", strings.Join(missing, ", "))
+	err = err.Challenge(insufficientScopeChallenge(required))
+	return classify(ErrInsufficientScope, "", res.Actor.ID, err)
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMethodScopes(t *testing.T) {
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{method: http.MethodGet, want: []string{ScopeRead}},
+		{method: http.MethodHead, want: []string{ScopeRead}},
+		{method: http.MethodOptions, want: []string{ScopeRead}},
+		{method: http.MethodPost, want: []string{ScopeWrite}},
+		{method: http.MethodDelete, want: []string{ScopeWrite}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com", nil)
+			if got := MethodScopes(r); !cmp.Equal(got, tt.want) {
+				t.Errorf("MethodScopes() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestVerificationResult_HasScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string
+		scopes  []string
+		want    bool
+	}{
+		{
+			name: "empty",
+			want: true,
+		},
+		{
+			name:    "nothing required",
+			granted: []string{ScopeRead},
+			want:    true,
+		},
+		{
+			name:   "nothing granted",
+			scopes: []string{ScopeRead},
+			want:   false,
+		},
+		{
+			name:    "all granted",
+			granted: []string{ScopeRead, ScopeWrite},
+			scopes:  []string{ScopeWrite, ScopeRead},
+			want:    true,
+		},
+		{
+			name:    "partially granted",
+			granted: []string{ScopeRead},
+			scopes:  []string{ScopeRead, ScopeWrite},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := VerificationResult{Scopes: tt.granted}
+			if got := res.HasScopes(tt.scopes...); got != tt.want {
+				t.Errorf("HasScopes() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clampScopes(t *testing.T) {
+	tests := []struct {
+		requested string
+		want      string
+		wantOk    bool
+	}{
+		{requested: "", want: "", wantOk: true},
+		{requested: "read", want: "read", wantOk: true},
+		{requested: "write read", want: "write read", wantOk: true},
+		{requested: "read  admin read", want: "read", wantOk: true},
+		{requested: "admin", want: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			got, ok := clampScopes(tt.requested)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("clampScopes() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_insufficientScopeChallenge(t *testing.T) {
+	want := `Bearer error="insufficient_scope", scope="read write"`
+	if got := insufficientScopeChallenge([]string{ScopeRead, ScopeWrite}); got != want {
+		t.Errorf("insufficientScopeChallenge() = %q, want %q", got, want)
+	}
+}
+
+func TestOAuth2_VerifyResult_scopes(t *testing.T) {
+	actor := mockActor()
+	bearer := url.Values{"Authorization": []string{"Bearer test"}}
+	tests := []struct {
+		name     string
+		scopesFn ScopesFn
+		want     VerificationResult
+		wantErr  error
+	}{
+		{
+			name: "no required scopes",
+			want: VerificationResult{
+				Method:            MethodOAuth2,
+				Actor:             actor,
+				Scopes:            []string{"none"},
+				ClientID:          "test-client",
+				ClientRedirectURI: "http://example.com",
+			},
+		},
+		{
+			name:     "granted scope",
+			scopesFn: func(*http.Request) []string { return []string{"none"} },
+			want: VerificationResult{
+				Method:            MethodOAuth2,
+				Actor:             actor,
+				Scopes:            []string{"none"},
+				ClientID:          "test-client",
+				ClientRedirectURI: "http://example.com",
+			},
+		},
+		{
+			name:     "missing scope",
+			scopesFn: MethodScopes,
+			want:     anonymousResult(),
+			wantErr:  errors.Forbiddenf("access token is missing the required scopes: read"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := oauthVerifier{
+				st:       st(&actor, mockAccess("test", defaultClient)),
+				l:        lw.Dev(lw.SetOutput(t.Output())),
+				scopesFn: tt.scopesFn,
+			}
+
+			got, err := s.VerifyResult(mockGetReq(bearer))
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("VerifyResult() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyResult() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+			if tt.wantErr == nil {
+				return
+			}
+			if !errors.Is(err, ErrInsufficientScope) {
+				t.Errorf("VerifyResult() error %v is not %v", err, ErrInsufficientScope)
+			}
+			if ch := errors.Challenge(err); ch != `Bearer error="insufficient_scope", scope="read"` {
+				t.Errorf("VerifyResult() challenge = %q", ch)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/openshift/osin"
+)
+
+// authorizationStore is the OAuth2 storage that can be used for issuing tokens.
+type authorizationStore interface {
+	oauthStore
+	osin.Storage
+}
+
+// passwordStore is the storage that can check the passwords of the local actors.
+// The method matches the one of the go-ap storage backends.
+type passwordStore interface {
+	readStore
+	PasswordCheck(it vocab.Item, pw []byte) error
+}
+
+// AuthenticateFn authenticates the local actor that is the resource owner of an authorization request.
+// It must return an Unauthorized error when the request doesn't contain valid credentials, in which
+// case the login form is presented.
+type AuthenticateFn func(r *http.Request) (vocab.Actor, error)
+
+// ActorIRIFn returns the IRI of the local actor corresponding to the handle used for logging in.
+type ActorIRIFn func(handle string) vocab.IRI
+
+// WithAuthenticator sets the function used by the authorization endpoint for authenticating the local actors.
+func WithAuthenticator(fn AuthenticateFn) InitFn {
+	return func(c *config) {
+		c.authFn = fn
+	}
+}
+
+// WithLoginTemplate replaces the login form shown by the authorization endpoint.
+// The template receives the Action URL the form must be posted to, the ClientID, the requested Scope,
+// and the Error of the previous attempt, if any.
+func WithLoginTemplate(t *template.Template) InitFn {
+	return func(c *config) {
+		c.loginTpl = t
+	}
+}
+
+// PasswordAuthenticator returns an AuthenticateFn that checks the "handle" and "pw" values posted with the login form.
+func PasswordAuthenticator(st passwordStore, iriFn ActorIRIFn) AuthenticateFn {
+	return func(r *http.Request) (vocab.Actor, error) {
+		if r.Method != http.MethodPost {
+			return AnonymousActor, errors.Unauthorizedf("missing credentials")
+		}
+		handle, pw := r.PostFormValue("handle"), r.PostFormValue("pw")
+		if handle == "" || pw == "" {
+			return AnonymousActor, errors.Unauthorizedf("missing credentials")
+		}
+		// NOTE(marius): we don't make a difference between unknown actors and wrong passwords,
+		// so the form can't be used for finding out which actors exist.
+		invalid := errors.Unauthorizedf("invalid handle or password")
+		act, err := loadActor(st, iriFn(handle))
+		if err != nil {
+			return AnonymousActor, invalid
+		}
+		if err = st.PasswordCheck(&act, []byte(pw)); err != nil {
+			return AnonymousActor, invalid
+		}
+		return act, nil
+	}
+}
+
+// osinStorage adapts the errors of the go-ap storage backends to the ones osin expects.
+type osinStorage struct {
+	authorizationStore
+	// documents loads the clients identified by the URL of their metadata document.
+	documents func(id string) (osin.Client, error)
+}
+
+func (s osinStorage) Clone() osin.Storage {
+	return s
+}
+
+func (s osinStorage) GetClient(id string) (osin.Client, error) {
+	if s.documents != nil && isURLClientID(id) {
+		return s.documents(id)
+	}
+	cl, err := s.authorizationStore.GetClient(id)
+	if errors.IsNotFound(err) {
+		return nil, osin.ErrNotFound
+	}
+	return cl, err
+}
+
+// osinLogger sends the osin debug messages to our logger.
+type osinLogger struct {
+	l lw.Logger
+}
+
+func (o osinLogger) Printf(format string, v ...any) {
+	o.l.Debugf(format, v...)
+}
+
+// server returns the osin server issuing tokens from the storage, for the request with the ctx context.
+// It supports the authorization_code, refresh_token and client_credentials grants.
+func (k oauthVerifier) server(ctx context.Context) (*osin.Server, error) {
+	st, ok := k.st.(authorizationStore)
+	if !ok {
+		return nil, errors.NotImplementedf("storage can't be used for issuing tokens")
+	}
+	cfg := osin.NewServerConfig()
+	cfg.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE}
+	cfg.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN, osin.CLIENT_CREDENTIALS}
+	cfg.ErrorStatusCode = http.StatusBadRequest
+	cfg.AllowClientSecretInParams = true
+	cfg.RequirePKCEForPublicClients = true
+	cfg.RedirectUriSeparator = redirectURISeparator
+
+	storage := osinStorage{authorizationStore: st}
+	if k.clientDocs != nil {
+		storage.documents = func(id string) (osin.Client, error) {
+			return k.clientDocument(ctx, id)
+		}
+	}
+	s := osin.NewServer(cfg, storage)
+	s.Now = k.now
+	s.Logger = osinLogger{l: k.l}
+	return s, nil
+}
+
+// writeResponse sends the osin response to the client, logging the internal errors.
+func (k oauthVerifier) writeResponse(w http.ResponseWriter, r *http.Request, resp *osin.Response) {
+	if resp.IsError && resp.InternalError != nil {
+		k.l.WithContext(lw.Ctx{"err": resp.InternalError.Error(), "code": resp.ErrorId}).Warnf("OAuth2 request failed")
+	}
+	if resp.IsError && resp.ErrorId == osin.E_INVALID_CLIENT {
+		resp.StatusCode = http.StatusUnauthorized
+	}
+	if resp.IsError && resp.ErrorId == osin.E_SERVER_ERROR {
+		resp.StatusCode = http.StatusInternalServerError
+	}
+	if err := osin.OutputJSON(resp, w, r); err != nil {
+		k.l.WithContext(lw.Ctx{"err": err.Error()}).Errorf("unable to write OAuth2 response")
+	}
+}
+
+type authorizeHandler struct {
+	oauthVerifier
+	authFn  AuthenticateFn
+	login   *template.Template
+	consent *template.Template
+	key     []byte
+}
+
+// AuthorizeHandler returns an http.Handler implementing the OAuth2 authorization endpoint for local actors.
+// The resource owner is authenticated with the function set using WithAuthenticator, and then asked to
+// approve the access of the client with a separate form, protected by a signed consent token.
+// The codes issued carry the IRI of the actor as their UserData, so the resulting access tokens can be
+// verified with OAuth2, and only the scopes we support are granted.
+// The clients without a secret must use a S256 PKCE challenge, and the "plain" method is rejected for all clients.
+func AuthorizeHandler(initFns ...InitFn) http.Handler {
+	c := Config(initFns...)
+	h := authorizeHandler{
+		oauthVerifier: newOAuthVerifier(c),
+		authFn:        c.authFn,
+		login:         c.loginTpl,
+		consent:       c.consentTpl,
+		key:           c.consentKey,
+	}
+	if h.login == nil {
+		h.login = defaultLoginTemplate
+	}
+	if h.consent == nil {
+		h.consent = defaultConsentTemplate
+	}
+	if len(h.key) == 0 {
+		h.key = randomKey()
+	}
+	return h
+}
+
+func (h authorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s, err := h.server(r.Context())
+	if err != nil || h.authFn == nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "authorization is not supported")
+		return
+	}
+	resp := s.NewResponse()
+	defer resp.Close()
+
+	ar := s.HandleAuthorizeRequest(resp, r)
+	if ar == nil {
+		h.writeResponse(w, r, resp)
+		return
+	}
+
+	if err = h.checkClient(ar.Client); err != nil {
+		resp.SetErrorState(osin.E_UNAUTHORIZED_CLIENT, "", ar.State)
+		h.writeResponse(w, r, resp)
+		return
+	}
+	if desc, ok := checkCodeChallenge(ar); !ok {
+		resp.SetErrorState(osin.E_INVALID_REQUEST, desc, ar.State)
+		h.writeResponse(w, r, resp)
+		return
+	}
+	scope, ok := clampScopes(ar.Scope)
+	if !ok {
+		resp.SetErrorState(osin.E_INVALID_SCOPE, "", ar.State)
+		h.writeResponse(w, r, resp)
+		return
+	}
+	ar.Scope = scope
+
+	if r.Method == http.MethodPost && r.PostFormValue(consentTokenField) != "" {
+		// NOTE(marius): the consent form is not authenticated again, the token proves that it was
+		// presented to the actor it was signed for.
+		c, err := verifyConsent(h.key, r.PostFormValue(consentTokenField), ar, h.now())
+		if err == nil {
+			err = h.useConsent(r.Context(), c)
+		}
+		if err != nil {
+			h.l.WithContext(lw.Ctx{"err": err.Error()}).Warnf("invalid consent")
+			writeOAuthError(w, http.StatusForbidden, "access_denied", "invalid consent token")
+			return
+		}
+		ar.Authorized = r.PostFormValue(consentField) == consentApprove
+		ar.UserData = c.Subject.String()
+		s.FinishAuthorizeRequest(resp, r, ar)
+		h.writeResponse(w, r, resp)
+		return
+	}
+
+	act, err := h.authFn(r)
+	if err != nil {
+		if !errors.IsUnauthorized(err) {
+			h.l.WithContext(lw.Ctx{"err": err.Error()}).Errorf("unable to authenticate actor")
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "unable to authenticate the resource owner")
+			return
+		}
+		h.showLogin(w, r, ar, err)
+		return
+	}
+	h.showConsent(w, r, ar, act.ID)
+}
+
+// showLogin presents the login form, with an error message for the failed attempts of POST requests.
+// NOTE(marius): the error returned by the authenticator is only logged, as it can contain internal details.
+func (h authorizeHandler) showLogin(w http.ResponseWriter, r *http.Request, ar *osin.AuthorizeRequest, err error) {
+	data := struct {
+		Action   string
+		ClientID string
+		Scope    string
+		Error    string
+	}{
+		Action:   r.URL.RequestURI(),
+		ClientID: ar.Client.GetId(),
+		Scope:    ar.Scope,
+	}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		h.l.WithContext(lw.Ctx{"err": err.Error(), "client": ar.Client.GetId()}).Warnf("unable to authenticate actor")
+		data.Error = "invalid credentials"
+		status = http.StatusUnauthorized
+	}
+	h.render(w, h.login, status, data)
+}
+
+// showConsent presents the authenticated actor the form for approving the access of the client.
+func (h authorizeHandler) showConsent(w http.ResponseWriter, r *http.Request, ar *osin.AuthorizeRequest, act vocab.IRI) {
+	data := struct {
+		Action   string
+		ClientID string
+		Scope    string
+		Actor    vocab.IRI
+		Token    string
+	}{
+		Action:   r.URL.RequestURI(),
+		ClientID: ar.Client.GetId(),
+		Scope:    ar.Scope,
+		Actor:    act,
+		Token:    signConsent(h.key, newConsentClaims(ar, act), h.now()),
+	}
+	h.render(w, h.consent, http.StatusOK, data)
+}
+
+// render writes the HTML form, which must not be cached nor framed by other sites.
+func (h authorizeHandler) render(w http.ResponseWriter, t *template.Template, status int, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := t.Execute(w, data); err != nil {
+		h.l.WithContext(lw.Ctx{"err": err.Error(), "template": t.Name()}).Errorf("unable to render form")
+	}
+}
+
+var defaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Log in</title></head>
+<body>
+<form method="post" action="{{ .Action }}">
+<p>Log in to authorize <strong>{{ .ClientID }}</strong>{{ if .Scope }} for <em>{{ .Scope }}</em>{{ end }}.</p>
+{{ if .Error }}<p role="alert">{{ .Error }}</p>{{ end }}
+<label>Handle <input type="text" name="handle" autocomplete="username" required></label>
+<label>Password <input type="password" name="pw" autocomplete="current-password" required></label>
+<button type="submit">Authorize</button>
+</form>
+</body>
+</html>
+`))
+
+type tokenHandler struct {
+	oauthVerifier
+}
+
+// TokenHandler returns an http.Handler implementing the OAuth2 token endpoint.
+// It exchanges the codes issued by AuthorizeHandler and the refresh tokens for new access tokens,
+// and issues access tokens for the client_credentials grant. For the latter, the UserData of the client
+// must be the IRI of the actor corresponding to the client application.
+// The public clients authenticate with only their client_id, the PKCE code_verifier proving their identity.
+func TokenHandler(initFns ...InitFn) http.Handler {
+	return tokenHandler{oauthVerifier: OAuth2(initFns...)}
+}
+
+func (h tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s, err := h.server(r.Context())
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "token issuing is not supported")
+		return
+	}
+	resp := s.NewResponse()
+	defer resp.Close()
+
+	allowPublicClient(r)
+	if ar := s.HandleAccessRequest(resp, r); ar != nil {
+		ar.Authorized = h.authorizeAccess(ar)
+		s.FinishAccessRequest(resp, r, ar)
+	}
+	h.writeResponse(w, r, resp)
+}
+
+// authorizeAccess decides if the access request can be granted.
+// The codes and refresh tokens already carry the actor IRI in their UserData, osin copies it to the new access data.
+func (h tokenHandler) authorizeAccess(ar *osin.AccessRequest) bool {
+	if err := h.checkClient(ar.Client); err != nil {
+		return false
+	}
+	switch ar.Type {
+	case osin.AUTHORIZATION_CODE, osin.REFRESH_TOKEN:
+		return ar.UserData != nil
+	case osin.CLIENT_CREDENTIALS:
+		if isPublicClient(ar.Client) {
+			return false
+		}
+		scope, ok := clampScopes(ar.Scope)
+		if !ok {
+			return false
+		}
+		ar.Scope = scope
+		iri, err := assertToBytes(ar.Client.GetUserData())
+		if err != nil || !isActorIRI(string(iri)) {
+			return false
+		}
+		ar.UserData = string(iri)
+		return true
+	}
+	return false
+}
+
+// isActorIRI checks that the UserData of a client is an IRI, and not the metadata of a registered client.
+func isActorIRI(iri string) bool {
+	u, err := url.Parse(iri)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
@@ -0,0 +1,483 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/openshift/osin"
+)
+
+type mockAuthStore struct {
+	*mockTokenStore
+	authorize map[string]*osin.AuthorizeData
+	passwords map[vocab.IRI]string
+}
+
+func authStore(cl osin.Client, el ...any) *mockAuthStore {
+	return &mockAuthStore{
+		mockTokenStore: tokenStore(cl, el...),
+		authorize:      make(map[string]*osin.AuthorizeData),
+		passwords:      map[vocab.IRI]string{"http://example.com/~jdoe": "secret"},
+	}
+}
+
+func (ms *mockAuthStore) Clone() osin.Storage { return ms }
+
+func (ms *mockAuthStore) Close() {}
+
+func (ms *mockAuthStore) SaveAuthorize(data *osin.AuthorizeData) error {
+	ms.authorize[data.Code] = data
+	return nil
+}
+
+func (ms *mockAuthStore) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	if data, ok := ms.authorize[code]; ok {
+		return data, nil
+	}
+	return nil, errors.NotFoundf("not found")
+}
+
+func (ms *mockAuthStore) RemoveAuthorize(code string) error {
+	delete(ms.authorize, code)
+	return nil
+}
+
+func (ms *mockAuthStore) SaveAccess(data *osin.AccessData) error {
+	ms.access[data.AccessToken] = data
+	if data.RefreshToken != "" {
+		ms.refresh[data.RefreshToken] = data
+	}
+	return nil
+}
+
+func (ms *mockAuthStore) PasswordCheck(it vocab.Item, pw []byte) error {
+	if want, ok := ms.passwords[it.GetLink()]; ok && want == string(pw) {
+		return nil
+	}
+	return errors.Unauthorizedf("invalid password")
+}
+
+func mockIRIFn(handle string) vocab.IRI {
+	return vocab.IRI("http://example.com/~" + handle)
+}
+
+func mockAuthorizeReq(method string, form url.Values) *http.Request {
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"test-client"},
+		"redirect_uri":  {"http://example.com"},
+		"state":         {"xyz"},
+		"scope":         {"read"},
+	}
+	u := "http://example.com/oauth/authorize?" + q.Encode()
+	if form == nil {
+		return httptest.NewRequest(method, u, nil)
+	}
+	r := httptest.NewRequest(method, u, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func mockTokenEndpointReq(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+var consentTokenRe = regexp.MustCompile(`name="consent_token" value="([^"]*)"`)
+
+// consentToken returns the consent token from the form in the w response.
+func consentToken(w *httptest.ResponseRecorder) string {
+	m := consentTokenRe.FindStringSubmatch(w.Body.String())
+	if len(m) < 2 {
+		return ""
+	}
+	return m[1]
+}
+
+// mockConsentReq builds the POST of the consent form for the r authorization request.
+func mockConsentReq(r *http.Request, tok, decision string) *http.Request {
+	form := url.Values{consentTokenField: {tok}, consentField: {decision}}
+	c := httptest.NewRequest(http.MethodPost, r.URL.String(), strings.NewReader(form.Encode()))
+	c.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c
+}
+
+// consent submits the decision for the consent form in the w response to the r authorization request.
+// The responses without a consent form are returned unchanged.
+func consent(t *testing.T, h http.Handler, r *http.Request, w *httptest.ResponseRecorder, decision string) *httptest.ResponseRecorder {
+	tok := consentToken(w)
+	if w.Code != http.StatusOK || tok == "" {
+		return w
+	}
+	t.Helper()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, mockConsentReq(r, tok, decision))
+	return w
+}
+
+func TestAuthorizeHandler(t *testing.T) {
+	actor := mockActor()
+	login := url.Values{"handle": {"jdoe"}, "pw": {"secret"}}
+
+	tests := []struct {
+		name       string
+		st         oauthStore
+		r          *http.Request
+		wantStatus int
+		wantCode   bool
+		wantBody   string
+	}{
+		{
+			name:       "storage can't issue tokens",
+			st:         st(&actor),
+			r:          mockAuthorizeReq(http.MethodGet, nil),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "unknown client",
+			st:         authStore(&osin.DefaultClient{Id: "other-client", RedirectUri: "http://example.com"}, &actor),
+			r:          mockAuthorizeReq(http.MethodGet, nil),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "unauthorized_client",
+		},
+		{
+			name:       "login form",
+			st:         authStore(defaultClient, &actor),
+			r:          mockAuthorizeReq(http.MethodGet, nil),
+			wantStatus: http.StatusOK,
+			wantBody:   `name="handle"`,
+		},
+		{
+			name:       "wrong password",
+			st:         authStore(defaultClient, &actor),
+			r:          mockAuthorizeReq(http.MethodPost, url.Values{"handle": {"jdoe"}, "pw": {"wrong"}}),
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "invalid credentials",
+		},
+		{
+			name:       "unknown actor",
+			st:         authStore(defaultClient, &actor),
+			r:          mockAuthorizeReq(http.MethodPost, url.Values{"handle": {"alice"}, "pw": {"secret"}}),
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "invalid credentials",
+		},
+		{
+			name:       "consent form",
+			st:         authStore(defaultClient, &actor),
+			r:          mockAuthorizeReq(http.MethodPost, login),
+			wantStatus: http.StatusOK,
+			wantBody:   `name="consent_token"`,
+		},
+		{
+			name:       "authorized",
+			st:         authStore(defaultClient, &actor),
+			r:          mockAuthorizeReq(http.MethodPost, login),
+			wantStatus: http.StatusFound,
+			wantCode:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authFn := func(r *http.Request) (vocab.Actor, error) { return AnonymousActor, errors.Unauthorizedf("no") }
+			if ps, ok := tt.st.(passwordStore); ok {
+				authFn = PasswordAuthenticator(ps, mockIRIFn)
+			}
+			h := AuthorizeHandler(WithLogger(lw.Dev(lw.SetOutput(t.Output()))), WithStorage(tt.st), WithAuthenticator(authFn))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.r)
+			if tt.wantCode {
+				w = consent(t, h, tt.r, w, consentApprove)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantBody != "" && !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("ServeHTTP() body = %s, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+			if !tt.wantCode {
+				return
+			}
+			loc, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("ServeHTTP() invalid redirect: %s", err)
+			}
+			if loc.Query().Get("state") != "xyz" {
+				t.Errorf("ServeHTTP() redirect state = %q, want %q", loc.Query().Get("state"), "xyz")
+			}
+			data, err := tt.st.(*mockAuthStore).LoadAuthorize(loc.Query().Get("code"))
+			if err != nil {
+				t.Fatalf("ServeHTTP() code was not saved: %s", err)
+			}
+			if data.UserData != actor.ID.String() {
+				t.Errorf("ServeHTTP() code user data = %v, want %s", data.UserData, actor.ID)
+			}
+		})
+	}
+}
+
+func TestAuthorizeHandler_consent(t *testing.T) {
+	actor := mockActor()
+	login := url.Values{"handle": {"jdoe"}, "pw": {"secret"}}
+	withScope := func(r *http.Request, scope string) *http.Request {
+		q := r.URL.Query()
+		q.Set("scope", scope)
+		r.URL.RawQuery = q.Encode()
+		return r
+	}
+
+	tests := []struct {
+		name       string
+		scope      string
+		decision   string
+		consentReq func(h http.Handler, r *http.Request, tok string) *http.Request
+		later      time.Duration
+		wantStatus int
+		wantError  string
+		wantScope  string
+	}{
+		{
+			name:       "approved",
+			scope:      "read",
+			decision:   consentApprove,
+			wantStatus: http.StatusFound,
+			wantScope:  "read",
+		},
+		{
+			name:       "denied",
+			scope:      "read",
+			decision:   "deny",
+			wantStatus: http.StatusFound,
+			wantError:  osin.E_ACCESS_DENIED,
+		},
+		{
+			name:       "unsupported scopes are dropped",
+			scope:      "read admin read",
+			decision:   consentApprove,
+			wantStatus: http.StatusFound,
+			wantScope:  "read",
+		},
+		{
+			name:       "only unsupported scopes",
+			scope:      "admin",
+			wantStatus: http.StatusFound,
+			wantError:  osin.E_INVALID_SCOPE,
+		},
+		{
+			name:     "missing token",
+			scope:    "read",
+			decision: consentApprove,
+			consentReq: func(_ http.Handler, r *http.Request, _ string) *http.Request {
+				return mockAuthorizeReq(http.MethodPost, url.Values{consentField: {consentApprove}})
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:     "tampered token",
+			scope:    "read",
+			decision: consentApprove,
+			consentReq: func(_ http.Handler, r *http.Request, tok string) *http.Request {
+				return mockConsentReq(r, tok+"x", consentApprove)
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:     "token for another request",
+			scope:    "read",
+			decision: consentApprove,
+			consentReq: func(_ http.Handler, r *http.Request, tok string) *http.Request {
+				return mockConsentReq(withScope(r, "read write"), tok, consentApprove)
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "expired token",
+			scope:      "read",
+			decision:   consentApprove,
+			later:      consentTTL + time.Minute,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:     "replayed token",
+			scope:    "read",
+			decision: consentApprove,
+			consentReq: func(h http.Handler, r *http.Request, tok string) *http.Request {
+				// NOTE(marius): the token is used once, the second request is the one we check
+				h.ServeHTTP(httptest.NewRecorder(), mockConsentReq(r, tok, consentApprove))
+				return mockConsentReq(r, tok, consentApprove)
+			},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			st := authStore(defaultClient, &actor)
+			h := AuthorizeHandler(
+				WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+				WithStorage(st),
+				WithAuthenticator(PasswordAuthenticator(st, mockIRIFn)),
+				WithClock(func() time.Time { return now }),
+			)
+
+			r := withScope(mockAuthorizeReq(http.MethodPost, login), tt.scope)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if tok := consentToken(w); tok != "" {
+				now = now.Add(tt.later)
+				c := mockConsentReq(r, tok, tt.decision)
+				if tt.consentReq != nil {
+					c = tt.consentReq(h, r, tok)
+				}
+				w = httptest.NewRecorder()
+				h.ServeHTTP(w, c)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusFound {
+				return
+			}
+			loc, _ := url.Parse(w.Header().Get("Location"))
+			if got := loc.Query().Get("error"); got != tt.wantError {
+				t.Errorf("ServeHTTP() redirect error = %q, want %q", got, tt.wantError)
+			}
+			if tt.wantError != "" {
+				return
+			}
+			data, err := st.LoadAuthorize(loc.Query().Get("code"))
+			if err != nil {
+				t.Fatalf("ServeHTTP() code was not saved: %s", err)
+			}
+			if data.Scope != tt.wantScope || data.UserData != actor.ID.String() {
+				t.Errorf("ServeHTTP() code scope = %q, user data = %v, want %q, %s", data.Scope, data.UserData, tt.wantScope, actor.ID)
+			}
+		})
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	actor := mockActor()
+	app := &osin.DefaultClient{Id: "app", Secret: "dsa", RedirectUri: "http://example.com", UserData: "http://example.com/~app"}
+
+	issue := func(t *testing.T, st *mockAuthStore, form url.Values, wantStatus int) map[string]any {
+		w := httptest.NewRecorder()
+		TokenHandler(WithLogger(lw.Dev(lw.SetOutput(t.Output()))), WithStorage(st)).ServeHTTP(w, mockTokenEndpointReq(form))
+		if w.Code != wantStatus {
+			t.Fatalf("ServeHTTP() status = %d, want %d: %s", w.Code, wantStatus, w.Body.String())
+		}
+		res := make(map[string]any)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("ServeHTTP() invalid response: %s", err)
+		}
+		return res
+	}
+	verify := func(t *testing.T, st *mockAuthStore, res map[string]any, want vocab.IRI) {
+		tok, _ := res["access_token"].(string)
+		got, err := OAuth2(WithStorage(st)).VerifyAccessCodeResult(tok)
+		if err != nil {
+			t.Fatalf("VerifyAccessCodeResult() for the issued token error = %v", err)
+		}
+		if got.Actor.ID != want {
+			t.Errorf("VerifyAccessCodeResult() for the issued token actor = %s, want %s", got.Actor.ID, want)
+		}
+	}
+
+	t.Run("authorization_code", func(t *testing.T) {
+		st := authStore(defaultClient, &actor)
+		_ = st.SaveAuthorize(&osin.AuthorizeData{
+			Client: defaultClient, Code: "code-1", ExpiresIn: 300, Scope: "read",
+			RedirectUri: "http://example.com", CreatedAt: time.Now(), UserData: actor.ID.String(),
+		})
+		res := issue(t, st, url.Values{
+			"grant_type": {"authorization_code"}, "code": {"code-1"}, "redirect_uri": {"http://example.com"},
+			"client_id": {"test-client"}, "client_secret": {"asd"},
+		}, http.StatusOK)
+		verify(t, st, res, actor.ID)
+		if _, err := st.LoadAuthorize("code-1"); err == nil {
+			t.Errorf("ServeHTTP() the code can be reused")
+		}
+		if res["refresh_token"] == nil {
+			t.Errorf("ServeHTTP() missing refresh token")
+		}
+
+		issue(t, st, url.Values{
+			"grant_type": {"authorization_code"}, "code": {"code-1"}, "redirect_uri": {"http://example.com"},
+			"client_id": {"test-client"}, "client_secret": {"asd"},
+		}, http.StatusBadRequest)
+	})
+
+	t.Run("refresh_token", func(t *testing.T) {
+		st := authStore(defaultClient, &actor, mockAccess("test", defaultClient))
+		res := issue(t, st, url.Values{
+			"grant_type": {"refresh_token"}, "refresh_token": {"refresh-666"},
+			"client_id": {"test-client"}, "client_secret": {"asd"},
+		}, http.StatusOK)
+		verify(t, st, res, actor.ID)
+		if _, err := st.LoadAccess("test"); err == nil {
+			t.Errorf("ServeHTTP() the refreshed access token is still valid")
+		}
+	})
+
+	t.Run("client_credentials", func(t *testing.T) {
+		appActor := vocab.Actor{ID: "http://example.com/~app", Type: vocab.ApplicationType}
+		st := authStore(app, &appActor)
+		res := issue(t, st, url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"dsa"}}, http.StatusOK)
+		verify(t, st, res, appActor.ID)
+		if res["refresh_token"] != nil {
+			t.Errorf("ServeHTTP() unexpected refresh token for client credentials")
+		}
+	})
+
+	t.Run("client_credentials with unsupported scopes", func(t *testing.T) {
+		appActor := vocab.Actor{ID: "http://example.com/~app", Type: vocab.ApplicationType}
+		st := authStore(app, &appActor)
+		res := issue(t, st, url.Values{
+			"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"dsa"}, "scope": {"read admin"},
+		}, http.StatusOK)
+		if !cmp.Equal(res["scope"], any("read")) {
+			t.Errorf("ServeHTTP() scope = %v, want read", res["scope"])
+		}
+		res = issue(t, st, url.Values{
+			"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"dsa"}, "scope": {"admin"},
+		}, http.StatusBadRequest)
+		if !cmp.Equal(res["error"], any("access_denied")) {
+			t.Errorf("ServeHTTP() error = %v, want access_denied", res["error"])
+		}
+	})
+
+	t.Run("client_credentials without actor", func(t *testing.T) {
+		st := authStore(defaultClient, &actor)
+		res := issue(t, st, url.Values{"grant_type": {"client_credentials"}, "client_id": {"test-client"}, "client_secret": {"asd"}}, http.StatusBadRequest)
+		if !cmp.Equal(res["error"], any("access_denied")) {
+			t.Errorf("ServeHTTP() error = %v, want access_denied", res["error"])
+		}
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		st := authStore(app, &actor)
+		res := issue(t, st, url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"wrong"}}, http.StatusBadRequest)
+		if !cmp.Equal(res["error"], any("unauthorized_client")) {
+			t.Errorf("ServeHTTP() error = %v, want unauthorized_client", res["error"])
+		}
+	})
+
+	t.Run("unsupported grant", func(t *testing.T) {
+		st := authStore(defaultClient, &actor)
+		res := issue(t, st, url.Values{"grant_type": {"password"}, "client_id": {"test-client"}, "client_secret": {"asd"}}, http.StatusBadRequest)
+		if !cmp.Equal(res["error"], any("unsupported_grant_type")) {
+			t.Errorf("ServeHTTP() error = %v, want unsupported_grant_type", res["error"])
+		}
+	})
+}
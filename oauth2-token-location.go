@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+// TokenLocation is a bit mask of the places in a request where we look for OAuth2 access tokens,
+// as described in RFC6750 section 2.
+type TokenLocation uint8
+
+const (
+	// TokenInHeader accepts access tokens in the Authorization request header.
+	TokenInHeader TokenLocation = 1 << iota
+	// TokenInBody accepts access tokens in the "access_token" parameter of form-encoded request bodies.
+	// NOTE(marius): looking for the token consumes the body of the form-encoded requests, its values
+	// remaining available only in the request's PostForm, so this should be enabled only for the handlers
+	// that don't read the body themselves.
+	TokenInBody
+	// TokenInQuery accepts access tokens in the "access_token" parameter of the URL query.
+	// NOTE(marius): the URLs end up in logs and browser histories, so this should be enabled only
+	// for clients that have no other way of sending the token, like the browser WebSocket or EventSource APIs.
+	TokenInQuery
+
+	// DefaultTokenLocations are the locations we accept access tokens from, when not configured otherwise.
+	DefaultTokenLocations = TokenInHeader
+)
+
+// accessTokenParam is the name of the form and query parameter containing the access token.
+const accessTokenParam = "access_token"
+
+// WithTokenLocations sets the locations in a request where OAuth2 access tokens are accepted from.
+func WithTokenLocations(loc TokenLocation) InitFn {
+	return func(c *config) {
+		c.tokenLocs = loc
+	}
+}
+
+func (k oauthVerifier) locations() TokenLocation {
+	if k.tokenLocs == 0 {
+		return DefaultTokenLocations
+	}
+	return k.tokenLocs
+}
+
+// formToken returns the access token from the body of the request, when it is form encoded, as
+// described in RFC6750 section 2.2.
+func formToken(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Body == nil {
+		return ""
+	}
+	if typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); typ != "application/x-www-form-urlencoded" {
+		return ""
+	}
+	return r.PostFormValue(accessTokenParam)
+}
+
+// queryToken returns the access token from the URL query of the request, as described in RFC6750 section 2.3.
+func queryToken(r *http.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+	return r.URL.Query().Get(accessTokenParam)
+}
+
+// hasAccessToken returns true if the request contains an access token outside the Authorization header,
+// in one of the locations we accept.
+func hasAccessToken(r *http.Request, loc TokenLocation) bool {
+	if loc == 0 {
+		loc = DefaultTokenLocations
+	}
+	return (loc&TokenInQuery != 0 && queryToken(r) != "") || (loc&TokenInBody != 0 && formToken(r) != "")
+}
+
+// requestToken returns the authorization scheme and the access token of the request, from the locations we accept.
+// Requests that contain the token in more than one location are rejected, as required by RFC6750 section 2.
+func (k oauthVerifier) requestToken(r *http.Request) (string, string, error) {
+	loc := k.locations()
+
+	var scheme, tok string
+	found := 0
+	if typ, cred := getAuthorization(r.Header.Get("Authorization")); loc&TokenInHeader != 0 && cred != "" {
+		if strings.EqualFold(typ, "Bearer") || typ == schemeDPoP {
+			scheme, tok = typ, cred
+			found++
+		}
+	}
+	if loc&TokenInBody != 0 {
+		if t := formToken(r); t != "" {
+			scheme, tok = "Bearer", t
+			found++
+		}
+	}
+	if loc&TokenInQuery != 0 {
+		if t := queryToken(r); t != "" {
+			scheme, tok = "Bearer", t
+			found++
+		}
+	}
+
+	switch found {
+	case 0:
+		// NOTE(marius): RFC6750 Section 3.1, the response to a request without credentials doesn't contain an error code.
+		err := errors.Unauthorizedf("could not load bearer token from request").Challenge("Bearer")
+		return "", "", classify(ErrMissingCredentials, "", "", err)
+	case 1:
+		return scheme, tok, nil
+	default:
+		err := errors.BadRequestf("the request contains more than one access token").
+			Challenge(`Bearer error="invalid_request", error_description="More than one access token"`)
+		return "", "", classify(ErrMalformed, "", "", err)
+	}
+}
+
+// redactURL returns a copy of u without the access token in the query, so it can be logged.
+func redactURL(u *url.URL) *url.URL {
+	if u == nil {
+		return &url.URL{}
+	}
+	cp := *u
+	if q := u.Query(); q.Has(accessTokenParam) {
+		q.Del(accessTokenParam)
+		cp.RawQuery = q.Encode()
+	}
+	return &cp
+}
+
+// redactHeaders returns a copy of h without the values of the headers carrying credentials, so it can be logged.
+func redactHeaders(h http.Header) http.Header {
+	cp := h.Clone()
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie", "DPoP"} {
+		if cp.Values(name) != nil {
+			cp.Set(name, "[redacted]")
+		}
+	}
+	return cp
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func mockTokenReq(method, header, query string, body url.Values) *http.Request {
+	u := "http://example.com/~jdoe/inbox"
+	if query != "" {
+		u += "?" + url.Values{accessTokenParam: {query}}.Encode()
+	}
+	var r *http.Request
+	if body != nil {
+		r = httptest.NewRequest(method, u, strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, u, nil)
+	}
+	if header != "" {
+		r.Header.Set("Authorization", "Bearer "+header)
+	}
+	return r
+}
+
+func TestOAuth2_VerifyResult_TokenLocations(t *testing.T) {
+	form := url.Values{accessTokenParam: {"test"}}
+	actor := mockActor()
+
+	tests := []struct {
+		name    string
+		loc     TokenLocation
+		r       *http.Request
+		want    vocab.Actor
+		wantErr error
+	}{
+		{
+			name: "header",
+			r:    mockTokenReq(http.MethodGet, "test", "", nil),
+			want: actor,
+		},
+		{
+			name:    "form body is disabled by default",
+			r:       mockTokenReq(http.MethodPost, "", "", form),
+			want:    AnonymousActor,
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name: "form body",
+			loc:  TokenInHeader | TokenInBody,
+			r:    mockTokenReq(http.MethodPost, "", "", form),
+			want: actor,
+		},
+		{
+			name:    "form body of GET request",
+			loc:     TokenInHeader | TokenInBody,
+			r:       mockTokenReq(http.MethodGet, "", "", form),
+			want:    AnonymousActor,
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name:    "query is disabled by default",
+			r:       mockTokenReq(http.MethodGet, "", "test", nil),
+			want:    AnonymousActor,
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name: "query",
+			loc:  TokenInQuery,
+			r:    mockTokenReq(http.MethodGet, "", "test", nil),
+			want: actor,
+		},
+		{
+			name:    "header is disabled",
+			loc:     TokenInQuery,
+			r:       mockTokenReq(http.MethodGet, "test", "", nil),
+			want:    AnonymousActor,
+			wantErr: ErrMissingCredentials,
+		},
+		{
+			name:    "header and form body",
+			loc:     TokenInHeader | TokenInBody,
+			r:       mockTokenReq(http.MethodPost, "test", "", form),
+			want:    AnonymousActor,
+			wantErr: ErrMalformed,
+		},
+		{
+			name:    "header and query",
+			loc:     TokenInHeader | TokenInQuery,
+			r:       mockTokenReq(http.MethodGet, "test", "test", nil),
+			want:    AnonymousActor,
+			wantErr: ErrMalformed,
+		},
+	}
+	for _, tt := range tests {
+		s := OAuth2(
+			WithLogger(lw.Dev(lw.SetOutput(t.Output()))),
+			WithStorage(st(&actor, mockAccess("test", defaultClient))),
+			WithTokenLocations(tt.loc),
+		)
+		t.Run(tt.name, func(t *testing.T) {
+			verifierTest(s, tt.r, tt.want, tt.wantErr)(t)
+			kind, ok := tt.wantErr.(ErrorKind)
+			if !ok {
+				return
+			}
+			_, err := s.VerifyResult(tt.r)
+			if status := errors.HttpStatus(err); status != kind.StatusCode() {
+				t.Errorf("VerifyResult() error status = %d, want %d", status, kind.StatusCode())
+			}
+			if errors.Challenge(err) == "" {
+				t.Errorf("VerifyResult() error = %v, missing the challenge", err)
+			}
+		})
+	}
+}
+
+func TestOAuth2_VerifyResult_keepsBody(t *testing.T) {
+	actor := mockActor()
+	form := url.Values{accessTokenParam: {"test"}}
+	r := mockTokenReq(http.MethodPost, "test", "", form)
+
+	s := OAuth2(WithStorage(st(&actor, mockAccess("test", defaultClient))))
+	if _, err := s.VerifyResult(r); err != nil {
+		t.Fatalf("VerifyResult() unexpected error = %v", err)
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("unable to read the request body: %s", err)
+	}
+	if string(raw) != form.Encode() {
+		t.Errorf("VerifyResult() consumed the request body, left %q", raw)
+	}
+}
+
+func Test_actorResolver_VerifyResult_QueryToken(t *testing.T) {
+	actor := mockActor()
+	storage := st(&actor, mockAccess("test", defaultClient))
+	r := mockTokenReq(http.MethodGet, "", "test", nil)
+
+	got, err := Verifier(WithStorage(storage)).VerifyResult(r)
+	if err != nil {
+		t.Fatalf("VerifyResult() unexpected error = %v", err)
+	}
+	if got.Method == MethodOAuth2 {
+		t.Errorf("VerifyResult() with the query token disabled got method = %v", got.Method)
+	}
+
+	got, err = Verifier(WithStorage(storage), WithTokenLocations(DefaultTokenLocations|TokenInQuery)).VerifyResult(r)
+	if err != nil {
+		t.Fatalf("VerifyResult() unexpected error = %v", err)
+	}
+	if !cmp.Equal(got.Actor, actor, EquateItems) {
+		t.Errorf("VerifyResult() actor = %s", cmp.Diff(actor, got.Actor, EquateItems))
+	}
+}
+
+func Test_redactURL(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com/inbox":                            "/inbox",
+		"http://example.com/inbox?page=2":                     "/inbox?page=2",
+		"http://example.com/inbox?access_token=secret":        "/inbox",
+		"http://example.com/inbox?access_token=secret&page=2": "/inbox?page=2",
+	}
+	for in, want := range tests {
+		u, _ := url.Parse(in)
+		got := redactURL(u).RequestURI()
+		if got != want {
+			t.Errorf("redactURL(%s) = %s, want %s", in, got, want)
+		}
+		if u.String() != in {
+			t.Errorf("redactURL(%s) modified the original URL: %s", in, u)
+		}
+	}
+}
+
+func Test_redactHeaders(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Dpop":          {"proof"},
+		"Cookie":        {"session=secret"},
+		"Signature":     {"sig1=:abc:"},
+	}
+	want := http.Header{
+		"Authorization": {"[redacted]"},
+		"Dpop":          {"[redacted]"},
+		"Cookie":        {"[redacted]"},
+		"Signature":     {"sig1=:abc:"},
+	}
+	if got := redactHeaders(h); !cmp.Equal(got, want) {
+		t.Errorf("redactHeaders() = %s", cmp.Diff(want, got))
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Errorf("redactHeaders() modified the original headers: %v", h)
+	}
+}
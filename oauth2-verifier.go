@@ -4,82 +4,222 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"strings"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
+	"github.com/dadrus/httpsig"
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	"github.com/openshift/osin"
 )
 
 type oauthVerifier struct {
-	st oauthStore
-	l  lw.Logger
+	st          oauthStore
+	l           lw.Logger
+	scopesFn    ScopesFn
+	clock       ClockFn
+	leeway      time.Duration
+	clientFn    ClientCheckFn
+	jwt         *jwtVerifier
+	ncFn        httpsig.NonceChecker
+	proxies     []netip.Prefix
+	forwarded   ForwardedHeaders
+	tokenLocs   TokenLocation
+	requirePKCE bool
+	c           ActivityPubClient
+	clientDocs  *clientDocuments
+	fetchPolicy RemoteFetchPolicy
 }
 
 // OAuth2
 func OAuth2(initFns ...InitFn) oauthVerifier {
-	c := Config(initFns...)
-	return oauthVerifier{
-		st: c.st,
-		l:  c.l,
+	return newOAuthVerifier(Config(initFns...))
+}
+
+func newOAuthVerifier(c config) oauthVerifier {
+	v := oauthVerifier{
+		st:          c.st,
+		l:           c.l,
+		scopesFn:    c.scopesFn,
+		clock:       c.clock,
+		leeway:      c.leeway,
+		clientFn:    c.clientFn,
+		jwt:         c.jwt,
+		ncFn:        c.ncFn,
+		proxies:     c.proxies,
+		forwarded:   c.forwarded,
+		tokenLocs:   c.tokenLocs,
+		requirePKCE: c.requirePKCE,
+		c:           c.c,
+		clientDocs:  c.clientDocs,
+		fetchPolicy: c.fetchPolicy,
 	}
+	return v
 }
 
 var (
-	errInvalidStorage = errors.Newf("invalid storage")
-	errInvalidClient  = errors.Newf("invalid client")
+	errInvalidStorage = &VerificationError{Kind: ErrMisconfigured, Err: errors.Newf("invalid storage")}
+	errInvalidClient  = &VerificationError{Kind: ErrMisconfigured, Err: errors.Newf("invalid client")}
 )
 
+// errUnauthorized returns an Unauthorized error with the OAuth2 challenge, classified as kind.
+func errUnauthorized(kind ErrorKind, err error) error {
+	return classify(kind, "", "", errors.NewUnauthorized(err, "Unauthorized").Challenge("oauth2"))
+}
+
 func (k oauthVerifier) VerifyAccessCode(tok string) (vocab.Actor, error) {
-	act := AnonymousActor
+	res, err := k.VerifyAccessCodeResult(tok)
+	return res.Actor, err
+}
+
+// VerifyAccessCodeResult loads the actor that the tok access token was issued for, together
+// with the scopes and client of the token.
+// The tokens bound to a DPoP key or to a client certificate are rejected, as proving the possession
+// of the key requires the request, so they need to be verified using VerifyResult.
+func (k oauthVerifier) VerifyAccessCodeResult(tok string) (VerificationResult, error) {
+	res, _, err := k.verifyAccess(tok)
+	if err != nil {
+		return res, err
+	}
+	if !res.confirmation().empty() {
+		unbound := errors.Unauthorizedf("access token is bound to a key, it can't be used without its request")
+		return anonymousResult(), classify(ErrBadSignature, "", "", unbound.Challenge(`Bearer error="invalid_token"`))
+	}
+	return res, nil
+}
+
+// verifyAccess behaves like VerifyAccessCodeResult, but it returns also the access data of the token.
+func (k oauthVerifier) verifyAccess(tok string) (VerificationResult, *osin.AccessData, error) {
+	res := anonymousResult()
+	at, err := k.loadAccess(tok)
+	if err != nil {
+		return res, nil, err
+	}
+	if err = k.checkExpiry(at.AccessData); err != nil {
+		return res, nil, err
+	}
+	if err = k.checkClient(at.Client); err != nil {
+		return res, nil, err
+	}
+	if err = k.checkPKCE(at); err != nil {
+		return res, nil, err
+	}
+	iri, err := assertToBytes(at.UserData)
+	if err != nil {
+		return res, nil, classify(ErrMalformed, "", "", errors.Unauthorizedf("unable to load from bearer"))
+	}
+	// NOTE(marius): for the self-contained access tokens we don't load the actor, the point of
+	// them being to avoid the storage lookups, so only its IRI is known.
+	act := vocab.Actor{ID: vocab.IRI(iri)}
+	if !at.offline {
+		if act, err = k.loadActor(vocab.IRI(iri)); err != nil {
+			return res, nil, err
+		}
+	}
+
+	res.Method = MethodOAuth2
+	res.Actor = act
+	res.Scopes = strings.Fields(at.Scope)
+	res.KeyThumbprint = at.cnf.JKT
+	res.CertificateThumbprint = at.cnf.X5T
+	if at.Client != nil {
+		res.ClientID = at.Client.GetId()
+		res.ClientRedirectURI = at.Client.GetRedirectUri()
+		res.ClientMetadata = at.Client.GetUserData()
+	}
+	return res, at.AccessData, nil
+}
+
+// accessToken holds the access data of a token, together with the details that osin doesn't keep.
+type accessToken struct {
+	*osin.AccessData
+	// offline is true when the access data was not loaded from the storage.
+	offline bool
+	// cnf holds the keys the token is bound to.
+	cnf Confirmation
+}
+
+// loadAccess loads the access data of tok, either from the storage, or for JWT access tokens, from the token itself.
+func (k oauthVerifier) loadAccess(tok string) (accessToken, error) {
+	if k.jwt != nil && isJWT(tok) {
+		return k.jwt.verify(tok, k.now().Add(k.leeway))
+	}
 	if k.st == nil {
-		return act, errInvalidStorage
+		return accessToken{}, errInvalidStorage
 	}
 	dat, err := k.st.LoadAccess(tok)
 	if err != nil {
-		return act, errors.NewUnauthorized(err, "Unauthorized").Challenge("oauth2")
+		return accessToken{}, errUnauthorized(ErrUnknownKey, err)
 	}
 	if dat == nil || dat.UserData == nil {
-		return act, errors.NotFoundf("unable to load access data")
+		return accessToken{}, classify(ErrUnknownKey, "", "", errors.NotFoundf("unable to load access data"))
 	}
-	if iri, err := assertToBytes(dat.UserData); err == nil {
-		it, err := k.st.Load(vocab.IRI(iri))
-		if err != nil {
-			return act, errors.NewUnauthorized(err, "Unauthorized").Challenge("oauth2")
-		}
-		if vocab.IsNil(it) {
-			return act, errors.NewUnauthorized(err, "Unauthorized").Challenge("oauth2")
-		}
-		if it, err = firstOrItem(it); err != nil {
-			return act, errors.NewUnauthorized(err, "Unauthorized").Challenge("oauth2")
-		}
-		err = vocab.OnActor(it, func(actor *vocab.Actor) error {
-			act = *actor
-			return nil
-		})
-		if err != nil {
-			return act, errors.NewUnauthorized(err, "Unauthorized").Challenge("oauth2")
-		}
-	} else {
-		return act, errors.Unauthorizedf("unable to load from bearer")
+	cnf, err := k.loadConfirmation(tok)
+	if err != nil {
+		return accessToken{}, err
+	}
+	return accessToken{AccessData: dat, cnf: cnf}, nil
+}
+
+// loadActor loads the actor the access token was issued for from the storage.
+func (k oauthVerifier) loadActor(iri vocab.IRI) (vocab.Actor, error) {
+	return loadActor(k.st, iri)
+}
+
+// loadActor loads the local actor with the iri IRI from the st storage.
+func loadActor(st readStore, iri vocab.IRI) (vocab.Actor, error) {
+	act := AnonymousActor
+	it, err := st.Load(iri)
+	if err != nil {
+		return act, errUnauthorized(ErrUnknownKey, err)
+	}
+	if vocab.IsNil(it) {
+		return act, errUnauthorized(ErrUnknownKey, err)
+	}
+	if it, err = firstOrItem(it); err != nil {
+		return act, errUnauthorized(ErrUnknownKey, err)
+	}
+	err = vocab.OnActor(it, func(actor *vocab.Actor) error {
+		act = *actor
+		return nil
+	})
+	if err != nil {
+		return AnonymousActor, errUnauthorized(ErrUnknownKey, err)
 	}
 	return act, nil
 }
 
 func (k oauthVerifier) Verify(r *http.Request) (vocab.Actor, error) {
+	res, err := k.VerifyResult(r)
+	return res.Actor, err
+}
+
+// VerifyResult loads the actor and token details for the OAuth2 bearer token present in the request.
+// The token is looked up in the locations set with WithTokenLocations.
+func (k oauthVerifier) VerifyResult(r *http.Request) (VerificationResult, error) {
 	if r == nil || r.Header == nil {
-		return AnonymousActor, nil
+		return anonymousResult(), nil
 	}
-	if k.st == nil {
-		return AnonymousActor, errInvalidStorage
+	if k.st == nil && k.jwt == nil {
+		return anonymousResult(), errInvalidStorage
 	}
-	act := AnonymousActor
-	bearer := osin.CheckBearerAuth(r)
-	if bearer == nil {
-		return act, errors.BadRequestf("could not load bearer token from request")
+	typ, tok, err := k.requestToken(r)
+	if err != nil {
+		return anonymousResult(), err
+	}
+	res, _, err := k.verifyAccess(tok)
+	if err != nil {
+		return res, err
+	}
+	if err = k.checkBinding(r, typ, tok, res.confirmation()); err != nil {
+		return anonymousResult(), err
+	}
+	if err = k.checkScopes(r, res); err != nil {
+		return anonymousResult(), err
 	}
-	return k.VerifyAccessCode(bearer.Code)
+	return res, nil
 }
 
 var AnonymousActor = vocab.Actor{
@@ -51,6 +51,59 @@ func TestOAuth2_VerifyAccessCode(t *testing.T) {
 	}
 }
 
+func TestOAuth2_VerifyAccessCodeResult(t *testing.T) {
+	actor := mockActor()
+	tests := []struct {
+		name    string
+		st      oauthStore
+		code    string
+		want    VerificationResult
+		wantErr error
+	}{
+		{
+			name:    "empty",
+			want:    anonymousResult(),
+			wantErr: errInvalidStorage,
+		},
+		{
+			name:    "unknown token",
+			st:      st(&actor, mockAccess("test", defaultClient)),
+			code:    "unknown",
+			want:    anonymousResult(),
+			wantErr: errors.NewUnauthorized(errors.NotFoundf("not found"), "Unauthorized"),
+		},
+		{
+			name: "valid token",
+			st:   st(&actor, mockAccess("test", defaultClient)),
+			code: "test",
+			want: VerificationResult{
+				Method:            MethodOAuth2,
+				Actor:             actor,
+				Scopes:            []string{"none"},
+				ClientID:          "test-client",
+				ClientRedirectURI: "http://example.com",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := oauthVerifier{
+				st: tt.st,
+				l:  lw.Dev(lw.SetOutput(t.Output())),
+			}
+
+			got, err := s.VerifyAccessCodeResult(tt.code)
+			if !cmp.Equal(err, tt.wantErr, EquateWeakErrors) {
+				t.Errorf("VerifyAccessCodeResult() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyAccessCodeResult() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+		})
+	}
+}
+
 var prv, _ = rsa.GenerateKey(rand.Reader, 1024)
 
 func pemEncodePublicKey(prvKey *rsa.PrivateKey) string {
@@ -173,7 +226,7 @@ func Test_oauthVerifier_Verify(t *testing.T) {
 			a:       oauthVerifier{st: st(), l: lw.Dev(lw.SetOutput(t.Output()))},
 			r:       mockGetReq(),
 			want:    AnonymousActor,
-			wantErr: errors.BadRequestf("could not load bearer token from request"),
+			wantErr: errors.Unauthorizedf("could not load bearer token from request"),
 		},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+)
+
+const (
+	// maxRemoteDocumentSize is the size limit of the documents we fetch from remote servers, like the public keys.
+	maxRemoteDocumentSize = 1 << 20
+	// remoteFetchTimeout is the time limit for fetching a document from a remote server.
+	remoteFetchTimeout = 10 * time.Second
+)
+
+// RemoteFetchPolicy restricts the hosts we fetch documents from.
+// The client metadata documents are always fetched from public hosts only, as their URLs are chosen by
+// the clients, while the public keys are fetched from any host, unless PublicKeys is set.
+type RemoteFetchPolicy struct {
+	// PublicKeys restricts the fetching of the public keys, and of the actors owning them, to public hosts.
+	PublicKeys bool
+	// AllowedNetworks are the non-public networks that the documents restricted to public hosts can still
+	// be fetched from, like the private network of a federation of servers.
+	AllowedNetworks []netip.Prefix
+}
+
+// WithRemoteFetchPolicy sets the hosts we fetch the public keys and the client metadata documents from.
+// The IP addresses of the hosts are checked at connection time only by the HTTP client of the ActivityPubClient,
+// see PublicTransport.
+func WithRemoteFetchPolicy(p RemoteFetchPolicy) InitFn {
+	return func(c *config) {
+		c.fetchPolicy = p
+	}
+}
+
+// checkPublic refuses the URLs of non-public hosts, outside the allowed networks.
+func (p RemoteFetchPolicy) checkPublic(u *url.URL) error {
+	return checkPublicURL(u, p.AllowedNetworks...)
+}
+
+// keyCheck returns the check of the URLs of the public keys and of their owners.
+func (p RemoteFetchPolicy) keyCheck() func(*url.URL) error {
+	if !p.PublicKeys {
+		return nil
+	}
+	return p.checkPublic
+}
+
+// fetchRemote loads the iri document using c, and returns the status and the body of the response.
+// The iri must be a HTTP URL that passes check, when set, and the request is bound to ctx, for at
+// most remoteFetchTimeout.
+func fetchRemote(ctx context.Context, c ActivityPubClient, iri, accept string, limit int64, check func(*url.URL) error) (int, []byte, error) {
+	u, err := url.Parse(iri)
+	if err != nil {
+		return 0, nil, errors.Annotatef(err, "invalid URL: %s", iri)
+	}
+	if err = checkRemoteURL(u); err != nil {
+		return 0, nil, err
+	}
+	if check != nil {
+		if err = check(u); err != nil {
+			return 0, nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, remoteFetchTimeout)
+	defer cancel()
+
+	req, err := client.FetchRequest(ctx, iri, http.MethodGet)
+	if err != nil {
+		return 0, nil, errors.Annotatef(err, "unable to create request: %s", iri)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, nil, errors.Annotatef(err, "unable to fetch: %s", iri)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := readLimited(resp.Body, limit)
+	if err != nil {
+		return resp.StatusCode, nil, errors.Annotatef(err, "unable to fetch: %s", iri)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// readLimited reads r up to limit bytes, and returns an error for larger contents, so a remote server
+// can't make us hold arbitrary amounts of data in memory.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errors.Newf("remote document is larger than %d bytes", limit)
+	}
+	return body, nil
+}
+
+// checkRemoteURL checks that u is an absolute HTTP URL, which can be fetched.
+func checkRemoteURL(u *url.URL) error {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.Newf("URL must use http or https")
+	}
+	if u.Hostname() == "" {
+		return errors.Newf("URL is missing the host")
+	}
+	return nil
+}
+
+// checkPublicURL refuses the URLs pointing to the loopback, private or link-local networks, outside the allowed ones,
+// or carrying credentials, so the documents that the clients ask us to fetch can't be used for reaching our internal services.
+// NOTE(marius): host names resolving to internal addresses can only be refused at connection time,
+// by the dialer of the HTTP client used by the ActivityPubClient, see PublicDialControl.
+func checkPublicURL(u *url.URL, allowed ...netip.Prefix) error {
+	if u.User != nil {
+		return errors.Newf("URL must not contain credentials")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.Newf("URL is missing the host")
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.Newf("URL host %s is not public", host)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil
+	}
+	if !isPublicAddr(addr, allowed...) {
+		return errors.Newf("URL host %s is not public", host)
+	}
+	return nil
+}
+
+// isPublicAddr checks that addr is not a loopback, private, link-local or otherwise reserved address,
+// or that it belongs to one of the allowed networks.
+func isPublicAddr(addr netip.Addr, allowed ...netip.Prefix) bool {
+	addr = addr.Unmap()
+	if slices.ContainsFunc(allowed, func(n netip.Prefix) bool { return n.Contains(addr) }) {
+		return true
+	}
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+// PublicDialControl is a net.Dialer Control function which refuses the connections to non-public addresses.
+// It should be set on the dialer of the HTTP client used by the ActivityPubClient passed to WithClient, as
+// it checks the addresses the host names resolved to, so unlike the checks of the URLs we fetch, it can't be
+// bypassed using DNS records pointing to our internal services.
+func PublicDialControl(network, address string, c syscall.RawConn) error {
+	return publicDialControl()(network, address, c)
+}
+
+// publicDialControl returns a net.Dialer Control function which refuses the connections to non-public addresses,
+// outside the allowed networks.
+func publicDialControl(allowed ...netip.Prefix) func(string, string, syscall.RawConn) error {
+	return func(_, address string, _ syscall.RawConn) error {
+		ap, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return errors.Annotatef(err, "invalid address %s", address)
+		}
+		if !isPublicAddr(ap.Addr(), allowed...) {
+			return errors.Newf("address %s is not public", ap.Addr())
+		}
+		return nil
+	}
+}
+
+// PublicTransport returns a copy of the default HTTP transport that connects only to public addresses,
+// and to the ones in the allowed networks, like PublicDialControl.
+// The allowed networks should match the RemoteFetchPolicy.AllowedNetworks.
+func PublicTransport(allowed ...netip.Prefix) *http.Transport {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicDialControl(allowed...)}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = d.DialContext
+	// NOTE(marius): the proxies would connect to the addresses on our behalf, bypassing the dialer.
+	t.Proxy = nil
+	return t
+}
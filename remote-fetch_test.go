@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_readLimited(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		wantErr bool
+	}{
+		{name: "empty", body: "", limit: 4},
+		{name: "under limit", body: "abc", limit: 4},
+		{name: "at limit", body: "abcd", limit: 4},
+		{name: "over limit", body: "abcde", limit: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readLimited(strings.NewReader(tt.body), tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readLimited() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.body {
+				t.Errorf("readLimited() = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func Test_checkPublicURL(t *testing.T) {
+	lan := netip.MustParsePrefix("192.168.0.0/16")
+	tests := []struct {
+		name    string
+		u       string
+		allowed []netip.Prefix
+		wantErr bool
+	}{
+		{name: "host name", u: "https://example.com/actor"},
+		{name: "public IPv4", u: "https://93.184.216.34/actor"},
+		{name: "public IPv6", u: "https://[2606:2800:220:1::1]/actor"},
+		{name: "credentials", u: "https://user@example.com/actor", wantErr: true},
+		{name: "no host", u: "https:///actor", wantErr: true},
+		{name: "localhost", u: "https://localhost/actor", wantErr: true},
+		{name: "localhost subdomain", u: "https://app.localhost/actor", wantErr: true},
+		{name: "loopback", u: "https://127.0.0.1:8443/actor", wantErr: true},
+		{name: "IPv6 loopback", u: "https://[::1]/actor", wantErr: true},
+		{name: "mapped loopback", u: "https://[::ffff:127.0.0.1]/actor", wantErr: true},
+		{name: "private network", u: "https://192.168.1.1/actor", wantErr: true},
+		{name: "link local", u: "https://169.254.169.254/actor", wantErr: true},
+		{name: "unspecified", u: "https://0.0.0.0/actor", wantErr: true},
+		{name: "allowed private network", u: "https://192.168.1.1/actor", allowed: []netip.Prefix{lan}},
+		{name: "other private network", u: "https://10.0.0.1/actor", allowed: []netip.Prefix{lan}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.u)
+			if err := checkPublicURL(u, tt.allowed...); (err != nil) != tt.wantErr {
+				t.Errorf("checkPublicURL() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fetchRemote(t *testing.T) {
+	c := docClient(map[string]any{mockClientID: map[string]string{"client_id": mockClientID}})
+
+	status, body, err := fetchRemote(context.Background(), c, mockClientID, "application/json", maxClientMetadataSize, nil)
+	if err != nil || status != http.StatusOK || len(body) == 0 {
+		t.Fatalf("fetchRemote() = %d, %s, %v", status, body, err)
+	}
+	if _, ok := c.last.Context().Deadline(); !ok {
+		t.Errorf("fetchRemote() made the request without a deadline")
+	}
+
+	public := RemoteFetchPolicy{}.checkPublic
+	if _, _, err = fetchRemote(context.Background(), c, "https://169.254.169.254/latest/meta-data", "", maxRemoteDocumentSize, public); err == nil {
+		t.Errorf("fetchRemote() for a link local address returned no error")
+	}
+	if _, _, err = fetchRemote(context.Background(), c, "test-key", "", maxRemoteDocumentSize, nil); err == nil {
+		t.Errorf("fetchRemote() for a relative URL returned no error")
+	}
+	if c.calls != 1 {
+		t.Errorf("fetchRemote() made %d requests, want 1", c.calls)
+	}
+
+	lan := RemoteFetchPolicy{AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")}}.checkPublic
+	if _, _, err = fetchRemote(context.Background(), c, "https://192.168.1.1/actor", "", maxRemoteDocumentSize, lan); err != nil {
+		t.Errorf("fetchRemote() for an allowed network returned error = %v", err)
+	}
+	if _, _, err = fetchRemote(context.Background(), c, "https://192.168.1.1/actor", "", maxRemoteDocumentSize, nil); err != nil {
+		t.Errorf("fetchRemote() without restrictions returned error = %v", err)
+	}
+	if c.calls != 3 {
+		t.Errorf("fetchRemote() made %d requests, want 3", c.calls)
+	}
+}
+
+func Test_publicDialControl(t *testing.T) {
+	control := publicDialControl(netip.MustParsePrefix("192.168.0.0/16"))
+	if err := control("tcp", "192.168.1.1:443", nil); err != nil {
+		t.Errorf("publicDialControl() for an allowed network returned error = %v", err)
+	}
+	if err := control("tcp", "10.0.0.1:443", nil); err == nil {
+		t.Errorf("publicDialControl() for a private network returned no error")
+	}
+}
+
+func TestPublicDialControl(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{address: "93.184.216.34:443"},
+		{address: "[2606:2800:220:1::1]:443"},
+		{address: "127.0.0.1:80", wantErr: true},
+		{address: "[::1]:443", wantErr: true},
+		{address: "[::ffff:10.0.0.1]:443", wantErr: true},
+		{address: "192.168.1.1:443", wantErr: true},
+		{address: "169.254.169.254:80", wantErr: true},
+		{address: "[fe80::1]:443", wantErr: true},
+		{address: "example.com:443", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if err := PublicDialControl("tcp", tt.address, nil); (err != nil) != tt.wantErr {
+				t.Errorf("PublicDialControl() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
 	"net/http"
+	"slices"
 	"sync"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
 	"github.com/dadrus/httpsig"
@@ -13,19 +16,51 @@ import (
 	"github.com/go-ap/errors"
 )
 
+// syncedNonceStore is the in memory httpsig.NonceChecker used when none was configured.
+// The nonces are remembered for ttl, or nonceTTL when it's not set, after which they get evicted.
 type syncedNonceStore struct {
-	sync.Map
+	ttl time.Duration
+
+	m     sync.Mutex
+	seen  map[string]time.Time
+	sweep time.Time
+}
+
+func (s *syncedNonceStore) expiry() time.Duration {
+	if s.ttl > 0 {
+		return s.ttl
+	}
+	return nonceTTL()
 }
 
-var errInvalidNonce = func(n string) error { return fmt.Errorf("nonce already seen: %s", n) }
+var errInvalidNonce = func(n string) error {
+	return &VerificationError{Kind: ErrReplayed, Err: fmt.Errorf("nonce already seen: %s", n)}
+}
 
 func (s *syncedNonceStore) CheckNonce(_ context.Context, n httpsig.NonceValue) error {
 	if !n.Present {
 		return nil
 	}
-	if _, exists := s.LoadOrStore(n.Value, struct{}{}); exists {
+	now := time.Now()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.seen == nil {
+		s.seen = make(map[string]time.Time)
+	}
+	if now.After(s.sweep) {
+		for key, expiresAt := range s.seen {
+			if !expiresAt.After(now) {
+				delete(s.seen, key)
+			}
+		}
+		s.sweep = now.Add(s.expiry())
+	}
+	if expiresAt, exists := s.seen[n.Value]; exists && expiresAt.After(now) {
 		return errInvalidNonce(n.Value)
 	}
+	s.seen[n.Value] = now.Add(s.expiry())
 	return nil
 }
 
@@ -34,88 +69,218 @@ type actorKeyLoader interface {
 	Actor() vocab.Actor
 }
 
-func signatureInputAlgorithm(header http.Header) (alg httpsig.SignatureAlgorithm) {
-	values := header.Values("Signature-Input")
-	inputDict, err := httpsfv.UnmarshalDictionary(values)
-	if err != nil {
-		return
+// signatureInputAlgorithms returns the "alg" parameters of all the Signature-Input labels, indexed by the label.
+func signatureInputAlgorithms(header http.Header) map[string]httpsig.SignatureAlgorithm {
+	algs := make(map[string]httpsig.SignatureAlgorithm)
+	for _, sig := range rfcSignatures(header) {
+		if sig.Algorithm == "" {
+			continue
+		}
+		algs[sig.Label] = httpsig.SignatureAlgorithm(sig.Algorithm)
 	}
+	return algs
+}
 
-	for _, label := range inputDict.Names() {
-		m, _ := inputDict.Get(label)
-
-		sigParams, ok := m.(httpsfv.InnerList)
-		if !ok {
-			return alg
-		}
-		param, ok := sigParams.Params.Get("alg")
-		if !ok {
-			return alg
-		}
-		value, ok := param.(string)
-		if !ok {
-			return alg
+// signatureValues returns the values from the Signature header, indexed by their label.
+func signatureValues(header http.Header) map[string][]byte {
+	sigDict, err := httpsfv.UnmarshalDictionary(header.Values("Signature"))
+	if err != nil {
+		return nil
+	}
+	values := make(map[string][]byte)
+	for _, label := range sigDict.Names() {
+		m, _ := sigDict.Get(label)
+		if it, ok := m.(httpsfv.Item); ok {
+			if raw, ok := it.Value.([]byte); ok {
+				values[label] = raw
+			}
 		}
-
-		alg = httpsig.SignatureAlgorithm(value)
 	}
+	return values
+}
 
-	return alg
+type signer struct {
+	actor vocab.Actor
+	alg   httpsig.SignatureAlgorithm
 }
 
-type hardcodedAlgResolver struct {
+// signerResolver applies the algorithm hints from the Signature-Input header to the keys that it resolves,
+// and keeps track of the actor owning each of them.
+// The hints, the signature values and the signers are indexed by label, as the same key can be used
+// for multiple signatures.
+type signerResolver struct {
 	actorKeyLoader
-	alg httpsig.SignatureAlgorithm
+	algs    map[string]httpsig.SignatureAlgorithm
+	signers map[string]signer
+	policy  KeyPolicy
+
+	// sigs contains the signature values, used for picking the RSA algorithm of the keys without an
+	// algorithm hint, see KeyPolicy.RSAPSSFallback.
+	sigs map[string][]byte
+
+	// label is the label of the signature that is being verified.
+	label string
+
+	// last is the ID of the most recently resolved key, which is the key of the signature that failed
+	// verification, as the signatures are verified one after the other.
+	last string
 }
 
-func (k hardcodedAlgResolver) ResolveKey(ctx context.Context, keyID string) (httpsig.Key, error) {
+func (k *signerResolver) ResolveKey(ctx context.Context, keyID string) (httpsig.Key, error) {
+	k.last = keyID
 	key, err := k.actorKeyLoader.ResolveKey(ctx, keyID)
-	if key.Algorithm == "" {
-		key.Algorithm = k.alg
+	if err != nil {
+		return key, classify(keyLoadKind(err), keyID, "", err)
 	}
-	return key, err
+	if hint, ok := k.algs[k.label]; ok {
+		// NOTE(marius): the algorithm from the Signature-Input takes precedence over the default one for the
+		// key type, when the key supports it, eg: RSA-PSS vs. PKCS #1 v1.5 for RSA keys.
+		if key.Algorithm == "" || supportsAlgorithm(key.Key, hint) {
+			key.Algorithm = hint
+		}
+	} else if pub, ok := key.Key.(*rsa.PublicKey); ok && k.policy.RSAPSSFallback {
+		// NOTE(marius): the padding of the signature tells us if it was created with RSA-PSS instead of
+		// PKCS #1 v1.5, so we pick the algorithm once, instead of verifying the signature with each of them.
+		algs := slices.DeleteFunc(pssAlgorithms(pub), func(alg httpsig.SignatureAlgorithm) bool {
+			return k.policy.checkAlgorithm(string(alg)) != nil
+		})
+		if alg := rsaPSSAlgorithm(pub, k.sigs[k.label], algs); alg != "" {
+			key.Algorithm = alg
+		}
+	}
+	if err = k.policy.checkKey(key.Key); err != nil {
+		return key, classify(ErrPolicy, keyID, k.actorKeyLoader.Actor().ID, errors.Annotatef(err, "public key %s rejected", keyID))
+	}
+	if err = k.policy.checkAlgorithm(string(key.Algorithm)); err != nil {
+		return key, classify(ErrPolicy, keyID, k.actorKeyLoader.Actor().ID, errors.Annotatef(err, "public key %s rejected", keyID))
+	}
+	k.signers[k.label] = signer{actor: k.actorKeyLoader.Actor(), alg: key.Algorithm}
+	return key, nil
+}
+
+// verify checks the signatures of msg, one label at a time, so that the algorithm hint and the signature value
+// of each label are used when resolving its key.
+// NOTE(marius): when verifying one of multiple labels, the Signature-Input header contains only that label,
+// so signatures covering the "signature-input" field of other labels can't be verified.
+func (k *signerResolver) verify(v httpsig.Verifier, msg *httpsig.Message) error {
+	inputDict, err := httpsfv.UnmarshalDictionary(msg.Header.Values("Signature-Input"))
+	if err != nil || len(inputDict.Names()) < 2 {
+		if err == nil && len(inputDict.Names()) == 1 {
+			k.label = inputDict.Names()[0]
+		}
+		return v.Verify(msg)
+	}
+	for _, label := range inputDict.Names() {
+		m, _ := inputDict.Get(label)
+		d := httpsfv.NewDictionary()
+		d.Add(label, m)
+		input, err := httpsfv.Marshal(d)
+		if err != nil {
+			return errors.Annotatef(err, "invalid Signature-Input for label %s", label)
+		}
+		k.label = label
+		single := *msg
+		single.Header = msg.Header.Clone()
+		single.Header.Set("Signature-Input", input)
+		if err = v.Verify(&single); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signatures returns the metadata of the signatures in the header, with the signing actor and the algorithm that
+// were used for verifying them.
+func (k *signerResolver) signatures(header http.Header) []Signature {
+	sigs := rfcSignatures(header)
+	for i, sig := range sigs {
+		s, ok := k.signers[sig.Label]
+		if !ok {
+			continue
+		}
+		sigs[i].Actor = s.actor
+		sigs[i].Algorithm = string(s.alg)
+	}
+	return sigs
 }
 
 // VerifyRFCSignature checks for RFC9421 compatible HTTP signatures.
 // It is based on the common-fate/httpsig/verifier.Parse functionality adapted for go-ap.
 func (k httpSigVerifier) VerifyRFCSignature(req *http.Request) (vocab.Actor, error) {
+	res, err := k.VerifyRFCSignatureResult(req)
+	return res.Actor, err
+}
+
+// VerifyRFCSignatureResult checks for RFC9421 compatible HTTP signatures and returns the actor that signed
+// the request together with the details of the signatures.
+func (k httpSigVerifier) VerifyRFCSignatureResult(req *http.Request) (VerificationResult, error) {
 	if req == nil {
-		return AnonymousActor, errInvalidRequest
+		return anonymousResult(), errInvalidRequest
 	}
-	resolver, ok := k.loader.(actorKeyLoader)
+	loader, ok := k.loader.(actorKeyLoader)
 	if !ok {
-		return AnonymousActor, errInvalidClient
+		return anonymousResult(), errInvalidClient
 	}
-	if k.ncFn == nil {
-		k.ncFn = new(syncedNonceStore)
+	if k.secrets != nil {
+		loader = &secretResolver{actorKeyLoader: loader, secrets: k.secrets, service: k.service}
 	}
-	if alg := signatureInputAlgorithm(req.Header); alg != "" {
-		resolver = hardcodedAlgResolver{alg: alg, actorKeyLoader: resolver}
+	resolver := &signerResolver{
+		actorKeyLoader: loader,
+		algs:           signatureInputAlgorithms(req.Header),
+		signers:        make(map[string]signer),
+		policy:         k.policy,
+		sigs:           signatureValues(req.Header),
 	}
-	// Create a verifier
-	verifier, err := httpsig.NewVerifier(
-		resolver,
+	opts := []httpsig.VerifierOption{
 		httpsig.WithNonceChecker(k.ncFn),
 		httpsig.WithValidityTolerance(sigValidDeltaDuration),
 		httpsig.WithMaxAge(sigMaxAgeDuration),
 		httpsig.WithCreatedTimestampRequired(false),
 		httpsig.WithExpiredTimestampRequired(false),
 		httpsig.WithValidateAllSignatures(),
-	)
+	}
+	if len(k.components) > 0 {
+		opts = append(opts, httpsig.WithRequiredComponents(k.components...))
+	}
+	// Create a verifier
+	verifier, err := httpsig.NewVerifier(resolver, opts...)
 	if err != nil {
-		return AnonymousActor, err
+		return anonymousResult(), err
 	}
 
 	msg := httpsig.MessageFromRequest(req)
-	if forwardedHost := req.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
-		msg.Authority = forwardedHost
+	if host, scheme := forwardedHostScheme(req, k.proxies, k.forwarded); host != "" || scheme != "" {
+		u := *msg.URL
+		if host != "" {
+			msg.Authority = host
+			if u.Host != "" {
+				u.Host = host
+			}
+		}
+		if scheme != "" {
+			u.Scheme = scheme
+			u.Host = msg.Authority
+		}
+		msg.URL = &u
 	}
-	if err = verifier.Verify(msg); err != nil {
-		k.l.WithContext(lw.Ctx{"headers": msg.Header, "authority": msg.Authority, "url": msg.URL.String(), "err": err}).Warnf("unable to verify actor")
+	if err = resolver.verify(verifier, msg); err != nil {
+		k.l.WithContext(lw.Ctx{"headers": redactHeaders(msg.Header), "authority": msg.Authority, "url": redactURL(msg.URL).String(), "err": err}).Warnf("unable to verify actor")
+		var actorID vocab.IRI
 		if act := resolver.Actor(); !vocab.IsNil(act) && act.ID != "" {
+			actorID = act.ID
 			err = errors.Annotatef(err, "actor IRI %s", act.ID)
 		}
-		return AnonymousActor, err
+		return anonymousResult(), classify(rfcErrorKind(err), resolver.last, actorID, err)
+	}
+	res := VerificationResult{
+		Method:     MethodRFCSignature,
+		Actor:      resolver.Actor(),
+		Signatures: resolver.signatures(req.Header),
+	}
+	// NOTE(marius): the actor of the request is the one that created the first signature, any other signers
+	// (eg, relays that forwarded the request) are available in the result's Signatures.
+	if len(res.Signatures) > 0 && res.Signatures[0].Actor.ID != "" {
+		res.Actor = res.Signatures[0].Actor
 	}
-	return resolver.Actor(), nil
+	return res, nil
 }
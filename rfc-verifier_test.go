@@ -1,4 +1,3 @@
-//go:debug rsa1024min=0
 package auth
 
 import (
@@ -6,8 +5,12 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
@@ -80,7 +83,7 @@ type mockLoader struct {
 	alg s2s.KeyEncoding
 }
 
-func (m mockLoader) loadKey(_ string) (vocab.Actor, *vocab.PublicKey, error) {
+func (m mockLoader) loadKey(_ context.Context, _ string) (vocab.Actor, *vocab.PublicKey, error) {
 	return m.it, &m.it.PublicKey, nil
 }
 
@@ -150,7 +153,7 @@ var enoughForOldTests = time.Since(time.UnixMicro(1618880000 * 1000 * 1000))
 
 type funcKeyLoader func(string) (vocab.Actor, *vocab.PublicKey, error)
 
-func (fn funcKeyLoader) loadKey(id string) (vocab.Actor, *vocab.PublicKey, error) {
+func (fn funcKeyLoader) loadKey(_ context.Context, id string) (vocab.Actor, *vocab.PublicKey, error) {
 	return fn(id)
 }
 
@@ -197,38 +200,8 @@ func (fn funcKeyLoader) ResolveKey(_ context.Context, keyID string) (httpsig.Key
 }
 
 var (
-	raw = []byte{
-		// RSA secret key:
-		// 3082013b020100024100e221e7c6506e8e3932e66fbf59cc580cd3ccdf688213426f1264144143d58e3d2c478d1cc93a1ab3c1c94cfc927e32f077fa6eb6fe0d59bb656e1c7613b1d53b020301000102404394a271f023ba3979eec842c5917e57070d594f2060a52010bcfc18ad2f2b7ca8b39e75179bd5abb3d161c8c0fe9053cd8de87ae84dd9dc3a84a2749040c261022100e3fd1ddb28613f9ead9869b392fa1f9d91bef1ab625605c968c72f5312ac77b3022100fdea673212cf6da2b1277b736a3b9f04662bb993d8754b1516e9a03010b6e85902205f1a54fbf8aa2869beac575b6b321f42116bff4fa8a38da268acbe16ff31267502210096da64650377e912f75d15a3044257bf2d545cf4d16d1e26716e6b9522d90841022100a960c9d14d424fb58de781aa700d8a838a46e5978cc74dcfdfb9dc16edfcf1ee
-		0x30, 0x82, 0x01, 0x3b, 0x02, 0x01, 0x00, 0x02, 0x41, 0x00, 0xe2, 0x21,
-		0xe7, 0xc6, 0x50, 0x6e, 0x8e, 0x39, 0x32, 0xe6, 0x6f, 0xbf, 0x59, 0xcc,
-		0x58, 0x0c, 0xd3, 0xcc, 0xdf, 0x68, 0x82, 0x13, 0x42, 0x6f, 0x12, 0x64,
-		0x14, 0x41, 0x43, 0xd5, 0x8e, 0x3d, 0x2c, 0x47, 0x8d, 0x1c, 0xc9, 0x3a,
-		0x1a, 0xb3, 0xc1, 0xc9, 0x4c, 0xfc, 0x92, 0x7e, 0x32, 0xf0, 0x77, 0xfa,
-		0x6e, 0xb6, 0xfe, 0x0d, 0x59, 0xbb, 0x65, 0x6e, 0x1c, 0x76, 0x13, 0xb1,
-		0xd5, 0x3b, 0x02, 0x03, 0x01, 0x00, 0x01, 0x02, 0x40, 0x43, 0x94, 0xa2,
-		0x71, 0xf0, 0x23, 0xba, 0x39, 0x79, 0xee, 0xc8, 0x42, 0xc5, 0x91, 0x7e,
-		0x57, 0x07, 0x0d, 0x59, 0x4f, 0x20, 0x60, 0xa5, 0x20, 0x10, 0xbc, 0xfc,
-		0x18, 0xad, 0x2f, 0x2b, 0x7c, 0xa8, 0xb3, 0x9e, 0x75, 0x17, 0x9b, 0xd5,
-		0xab, 0xb3, 0xd1, 0x61, 0xc8, 0xc0, 0xfe, 0x90, 0x53, 0xcd, 0x8d, 0xe8,
-		0x7a, 0xe8, 0x4d, 0xd9, 0xdc, 0x3a, 0x84, 0xa2, 0x74, 0x90, 0x40, 0xc2,
-		0x61, 0x02, 0x21, 0x00, 0xe3, 0xfd, 0x1d, 0xdb, 0x28, 0x61, 0x3f, 0x9e,
-		0xad, 0x98, 0x69, 0xb3, 0x92, 0xfa, 0x1f, 0x9d, 0x91, 0xbe, 0xf1, 0xab,
-		0x62, 0x56, 0x05, 0xc9, 0x68, 0xc7, 0x2f, 0x53, 0x12, 0xac, 0x77, 0xb3,
-		0x02, 0x21, 0x00, 0xfd, 0xea, 0x67, 0x32, 0x12, 0xcf, 0x6d, 0xa2, 0xb1,
-		0x27, 0x7b, 0x73, 0x6a, 0x3b, 0x9f, 0x04, 0x66, 0x2b, 0xb9, 0x93, 0xd8,
-		0x75, 0x4b, 0x15, 0x16, 0xe9, 0xa0, 0x30, 0x10, 0xb6, 0xe8, 0x59, 0x02,
-		0x20, 0x5f, 0x1a, 0x54, 0xfb, 0xf8, 0xaa, 0x28, 0x69, 0xbe, 0xac, 0x57,
-		0x5b, 0x6b, 0x32, 0x1f, 0x42, 0x11, 0x6b, 0xff, 0x4f, 0xa8, 0xa3, 0x8d,
-		0xa2, 0x68, 0xac, 0xbe, 0x16, 0xff, 0x31, 0x26, 0x75, 0x02, 0x21, 0x00,
-		0x96, 0xda, 0x64, 0x65, 0x03, 0x77, 0xe9, 0x12, 0xf7, 0x5d, 0x15, 0xa3,
-		0x04, 0x42, 0x57, 0xbf, 0x2d, 0x54, 0x5c, 0xf4, 0xd1, 0x6d, 0x1e, 0x26,
-		0x71, 0x6e, 0x6b, 0x95, 0x22, 0xd9, 0x08, 0x41, 0x02, 0x21, 0x00, 0xa9,
-		0x60, 0xc9, 0xd1, 0x4d, 0x42, 0x4f, 0xb5, 0x8d, 0xe7, 0x81, 0xaa, 0x70,
-		0x0d, 0x8a, 0x83, 0x8a, 0x46, 0xe5, 0x97, 0x8c, 0xc7, 0x4d, 0xcf, 0xdf,
-		0xb9, 0xdc, 0x16, 0xed, 0xfc, 0xf1, 0xee,
-	}
-	mitraPrv, _ = x509.ParsePKCS1PrivateKey(raw)
+	// NOTE(marius): the original example was signed with a 512 bit key, we re-signed it with the RFC9421 test key.
+	mitraPrv = prvKeyRSA1
 
 	mitraActor = vocab.Actor{
 		ID: "https://signer.example/actor",
@@ -369,7 +342,7 @@ func Test_httpSigVerifier_VerifyRFCSignature(t *testing.T) {
 				//request URI: https://verifier.example/inbox
 				//created: 1778314593
 				//content-digest header: sha-256=:RBNvo1WzZ4oRRq0W9+hknpT7T8If536DEMBg9hyq/4o=:
-				//signature header: sig1=:gJWUQjvkEcdXc86ZC+kEWKhUyiExKQomXxWd9q8mzDSm9fE6XjsA+HCoNE9LP4RRCdwAHWZ6Zeou4WPjhxpPwQ==:
+				//signature header: sig1=:SDT2KLSrBXexZa6Ec3D/ZGM4kKFRb1zdoPyNrqWJ1f1VhC8kvvY1th7jFbbJcBtFbCK4WuYIB/wTDKNOTZ25AiwzNNQM17+HKtM/3EubjYESnc5aH/AYDKkenxLdk2lcB3jBi5bFAmkOanFcBdRXhKPINaMR0u3li/0A69HqesbJBuFTWwjkslTuoFSJIoi6CFHWCO9jamOQAlGf2ATHz6ygYyGpnbfFj0g9HRBC6veYq68AO6aboBRJ8Pvea6OV4luKF96EphJ5VnXOdlzs7GfGRC37BEZLiMT7cNLoGJ9i/WejZOHMVNxu/x1Qvqy4+C4b9zZa1FaTnkLR5W8mHQ==:
 				//signature-input header: sig1=("@method" "@target-uri" "content-digest");keyid="https://signer.example/actor#main-key";created=1778314593;alg="rsa-v1_5-sha256"
 				// ----
 				//signature base:
@@ -386,7 +359,7 @@ func Test_httpSigVerifier_VerifyRFCSignature(t *testing.T) {
 				req := httptest.NewRequest(http.MethodPost, "https://verifier.example/inbox", strings.NewReader("{}"))
 				req.Header.Add("Content-Digest", "sha-256=:RBNvo1WzZ4oRRq0W9+hknpT7T8If536DEMBg9hyq/4o=:")
 				req.Header.Add("Signature-Input", `sig1=("@method" "@target-uri" "content-digest");keyid="https://signer.example/actor#main-key";created=1778314593;alg="rsa-v1_5-sha256"`)
-				req.Header.Add("Signature", "sig1=:gJWUQjvkEcdXc86ZC+kEWKhUyiExKQomXxWd9q8mzDSm9fE6XjsA+HCoNE9LP4RRCdwAHWZ6Zeou4WPjhxpPwQ==:")
+				req.Header.Add("Signature", "sig1=:SDT2KLSrBXexZa6Ec3D/ZGM4kKFRb1zdoPyNrqWJ1f1VhC8kvvY1th7jFbbJcBtFbCK4WuYIB/wTDKNOTZ25AiwzNNQM17+HKtM/3EubjYESnc5aH/AYDKkenxLdk2lcB3jBi5bFAmkOanFcBdRXhKPINaMR0u3li/0A69HqesbJBuFTWwjkslTuoFSJIoi6CFHWCO9jamOQAlGf2ATHz6ygYyGpnbfFj0g9HRBC6veYq68AO6aboBRJ8Pvea6OV4luKF96EphJ5VnXOdlzs7GfGRC37BEZLiMT7cNLoGJ9i/WejZOHMVNxu/x1Qvqy4+C4b9zZa1FaTnkLR5W8mHQ==:")
 				return req
 			}(),
 			sigDuration: 10000 * time.Hour,
@@ -529,3 +502,293 @@ func Test_httpSigVerifier_VerifyRFCSignature_empty_nonce_check(t *testing.T) {
 		})
 	}
 }
+
+// multiKeyLoader resolves the keys of multiple actors, leaving the algorithm to be determined
+// from the Signature-Input parameters.
+type multiKeyLoader struct {
+	actors map[string]vocab.Actor
+	last   vocab.Actor
+}
+
+func (m *multiKeyLoader) loadKey(_ context.Context, id string) (vocab.Actor, *vocab.PublicKey, error) {
+	act, ok := m.actors[id]
+	if !ok {
+		return AnonymousActor, nil, errors.NotFoundf("not found %s", id)
+	}
+	return act, &act.PublicKey, nil
+}
+
+func (m *multiKeyLoader) Actor() vocab.Actor {
+	return m.last
+}
+
+func (m *multiKeyLoader) ResolveKey(ctx context.Context, id string) (httpsig.Key, error) {
+	act, pub, err := m.loadKey(ctx, id)
+	if err != nil {
+		return httpsig.Key{}, err
+	}
+	m.last = act
+	pk, err := toCryptoPublicKey(*pub)
+	return httpsig.Key{KeyID: id, Key: pk}, err
+}
+
+func Test_httpSigVerifier_VerifyRFCSignatureResult_multiple_signers(t *testing.T) {
+	origin := mockRFCActor(prvKeyRSA1, "http://example.com/~jdoe#main")
+	relay := vocab.Actor{
+		ID:        "http://relay.example.com/actor",
+		Type:      vocab.ApplicationType,
+		PublicKey: mockActorGenKey("http://relay.example.com/actor#main", "http://relay.example.com/actor", prvKeyECDSA),
+	}
+
+	req := mockPostReq([]byte(`{"hello": "world"}`))
+	signers := []struct {
+		label string
+		key   httpsig.Key
+	}{
+		{label: "origin", key: httpsig.Key{KeyID: string(origin.PublicKey.ID), Algorithm: httpsig.RsaPssSha512, Key: prvKeyRSA1}},
+		{label: "relay", key: httpsig.Key{KeyID: string(relay.PublicKey.ID), Algorithm: httpsig.EcdsaP256Sha256, Key: prvKeyECDSA}},
+	}
+	for _, s := range signers {
+		sig, err := httpsig.NewSigner(s.key, httpsig.WithLabel(s.label), httpsig.WithComponents("@method", "@target-uri"))
+		if err != nil {
+			t.Fatalf("unable to create signer: %s", err)
+		}
+		hdr, err := sig.Sign(httpsig.MessageFromRequest(req))
+		if err != nil {
+			t.Fatalf("unable to sign request: %s", err)
+		}
+		req.Header = hdr
+	}
+
+	loader := &multiKeyLoader{actors: map[string]vocab.Actor{
+		string(origin.PublicKey.ID): origin,
+		string(relay.PublicKey.ID):  relay,
+	}}
+	k := httpSigVerifier{loader: loader, l: lw.Dev(lw.SetOutput(t.Output()))}
+	res, err := k.VerifyRFCSignatureResult(req)
+	if err != nil {
+		t.Fatalf("VerifyRFCSignatureResult() unexpected error = %s", err)
+	}
+	if res.Method != MethodRFCSignature {
+		t.Errorf("VerifyRFCSignatureResult() method = %s, want %s", res.Method, MethodRFCSignature)
+	}
+	if !cmp.Equal(res.Actor, origin, EquateItems) {
+		t.Errorf("VerifyRFCSignatureResult() actor = %s", cmp.Diff(origin, res.Actor, EquateItems))
+	}
+
+	want := map[string]struct {
+		actor vocab.Actor
+		alg   string
+	}{
+		"origin": {actor: origin, alg: string(httpsig.RsaPssSha512)},
+		"relay":  {actor: relay, alg: string(httpsig.EcdsaP256Sha256)},
+	}
+	if len(res.Signatures) != len(want) {
+		t.Fatalf("VerifyRFCSignatureResult() signatures = %d, want %d", len(res.Signatures), len(want))
+	}
+	for _, sig := range res.Signatures {
+		w, ok := want[sig.Label]
+		if !ok {
+			t.Errorf("VerifyRFCSignatureResult() unexpected signature %s", sig.Label)
+			continue
+		}
+		if sig.Algorithm != w.alg {
+			t.Errorf("VerifyRFCSignatureResult() signature %s algorithm = %s, want %s", sig.Label, sig.Algorithm, w.alg)
+		}
+		if !cmp.Equal(sig.Actor, w.actor, EquateItems) {
+			t.Errorf("VerifyRFCSignatureResult() signature %s actor = %s", sig.Label, cmp.Diff(w.actor, sig.Actor, EquateItems))
+		}
+	}
+}
+
+func Test_httpSigVerifier_VerifyRFCSignatureResult_same_key_multiple_algorithms(t *testing.T) {
+	actor := mockRFCActor(prvKeyRSA1, "http://example.com/~jdoe#main")
+
+	req := mockGetReq()
+	want := map[string]httpsig.SignatureAlgorithm{
+		"pss":   httpsig.RsaPssSha512,
+		"pkcs1": httpsig.RsaPkcs1v15Sha256,
+	}
+	for _, label := range []string{"pss", "pkcs1"} {
+		key := httpsig.Key{KeyID: string(actor.PublicKey.ID), Algorithm: want[label], Key: prvKeyRSA1}
+		sig, err := httpsig.NewSigner(key, httpsig.WithLabel(label), httpsig.WithComponents("@method", "@target-uri"))
+		if err != nil {
+			t.Fatalf("unable to create signer: %s", err)
+		}
+		hdr, err := sig.Sign(httpsig.MessageFromRequest(req))
+		if err != nil {
+			t.Fatalf("unable to sign request: %s", err)
+		}
+		req.Header = hdr
+	}
+
+	loader := &multiKeyLoader{actors: map[string]vocab.Actor{string(actor.PublicKey.ID): actor}}
+	k := httpSigVerifier{loader: loader, l: lw.Dev(lw.SetOutput(t.Output()))}
+	res, err := k.VerifyRFCSignatureResult(req)
+	if err != nil {
+		t.Fatalf("VerifyRFCSignatureResult() unexpected error = %s", err)
+	}
+	if len(res.Signatures) != len(want) {
+		t.Fatalf("VerifyRFCSignatureResult() signatures = %d, want %d", len(res.Signatures), len(want))
+	}
+	for _, sig := range res.Signatures {
+		if sig.Algorithm != string(want[sig.Label]) {
+			t.Errorf("VerifyRFCSignatureResult() signature %s algorithm = %s, want %s", sig.Label, sig.Algorithm, want[sig.Label])
+		}
+	}
+}
+
+func Test_signatureInputAlgorithms(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   map[string]httpsig.SignatureAlgorithm
+	}{
+		{
+			name:   "empty",
+			header: http.Header{},
+			want:   map[string]httpsig.SignatureAlgorithm{},
+		},
+		{
+			name: "no alg",
+			header: http.Header{
+				"Signature-Input": {`sig1=("@method");keyid="test-key-rsa"`},
+			},
+			want: map[string]httpsig.SignatureAlgorithm{},
+		},
+		{
+			name: "different algorithms per key",
+			header: http.Header{
+				"Signature-Input": {`sig1=("@method");keyid="test-key-rsa";alg="rsa-pss-sha512", sig2=("@method");keyid="test-key-ecc-p256";alg="ecdsa-p256-sha256"`},
+			},
+			want: map[string]httpsig.SignatureAlgorithm{
+				"sig1": httpsig.RsaPssSha512,
+				"sig2": httpsig.EcdsaP256Sha256,
+			},
+		},
+		{
+			name: "same key, different algorithms per label",
+			header: http.Header{
+				"Signature-Input": {`sig1=("@method");keyid="test-key-rsa";alg="rsa-pss-sha512", sig2=("@method");keyid="test-key-rsa";alg="rsa-v1_5-sha256"`},
+			},
+			want: map[string]httpsig.SignatureAlgorithm{
+				"sig1": httpsig.RsaPssSha512,
+				"sig2": httpsig.RsaPkcs1v15Sha256,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signatureInputAlgorithms(tt.header); !cmp.Equal(got, tt.want) {
+				t.Errorf("signatureInputAlgorithms() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+// pssSignedReq returns a GET request signed using RSA-PSS with SHA-512, with the alg parameter
+// present in the Signature-Input only when withAlg is set.
+func pssSignedReq(t *testing.T, keyID string, prv *rsa.PrivateKey, withAlg bool) *http.Request {
+	alg := ""
+	if withAlg {
+		alg = string(httpsig.RsaPssSha512)
+	}
+	return rsaSignedReq(t, keyID, alg, func(base []byte) ([]byte, error) {
+		hashed := sha512.Sum512(base)
+		return rsa.SignPSS(rand.Reader, prv, crypto.SHA512, hashed[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	})
+}
+
+// pkcs1SignedReq returns a GET request signed using RSA PKCS #1 v1.5 with SHA-256, without an alg parameter.
+func pkcs1SignedReq(t *testing.T, keyID string, prv *rsa.PrivateKey) *http.Request {
+	return rsaSignedReq(t, keyID, "", func(base []byte) ([]byte, error) {
+		hashed := sha256.Sum256(base)
+		return rsa.SignPKCS1v15(rand.Reader, prv, crypto.SHA256, hashed[:])
+	})
+}
+
+func rsaSignedReq(t *testing.T, keyID, alg string, sign func([]byte) ([]byte, error)) *http.Request {
+	req := mockGetReq()
+	params := fmt.Sprintf(`("@method" "@authority");created=%d;keyid="%s"`, time.Now().Unix(), keyID)
+	if alg != "" {
+		params += fmt.Sprintf(`;alg="%s"`, alg)
+	}
+	base := fmt.Sprintf("\"@method\": %s\n\"@authority\": %s\n\"@signature-params\": %s", req.Method, req.Host, params)
+	sig, err := sign([]byte(base))
+	if err != nil {
+		t.Fatalf("unable to sign request: %s", err)
+	}
+	req.Header.Set("Signature-Input", "sig1="+params)
+	req.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return req
+}
+
+func Test_httpSigVerifier_VerifyRFCSignature_RSAPSS(t *testing.T) {
+	actor := mockRFCActor(prvKeyRSA1, "http://example.com/~jdoe#main")
+	// NOTE(marius): the multiKeyLoader doesn't return an algorithm, so we use a loader that infers
+	// PKCS #1 v1.5 from the RSA key like the localRemoteLoader does.
+	loader := funcKeyLoader(func(string) (vocab.Actor, *vocab.PublicKey, error) {
+		return actor, &actor.PublicKey, nil
+	})
+
+	tests := []struct {
+		name    string
+		policy  KeyPolicy
+		req     *http.Request
+		want    vocab.Actor
+		wantErr bool
+	}{
+		{
+			name:   "explicit alg",
+			policy: KeyPolicy{},
+			req:    pssSignedReq(t, string(actor.PublicKey.ID), prvKeyRSA1, true),
+			want:   actor,
+		},
+		{
+			name:    "explicit alg, not allowed by policy",
+			policy:  KeyPolicy{Algorithms: []string{string(httpsig.RsaPkcs1v15Sha256)}},
+			req:     pssSignedReq(t, string(actor.PublicKey.ID), prvKeyRSA1, true),
+			want:    AnonymousActor,
+			wantErr: true,
+		},
+		{
+			name:   "no alg, with fallback",
+			policy: DefaultKeyPolicy,
+			req:    pssSignedReq(t, string(actor.PublicKey.ID), prvKeyRSA1, false),
+			want:   actor,
+		},
+		{
+			name:   "no alg, PKCS #1 v1.5 with fallback",
+			policy: DefaultKeyPolicy,
+			req:    pkcs1SignedReq(t, string(actor.PublicKey.ID), prvKeyRSA1),
+			want:   actor,
+		},
+		{
+			name:    "no alg, fallback not allowed by policy",
+			policy:  KeyPolicy{RSAPSSFallback: true, Algorithms: []string{string(httpsig.RsaPkcs1v15Sha256)}},
+			req:     pssSignedReq(t, string(actor.PublicKey.ID), prvKeyRSA1, false),
+			want:    AnonymousActor,
+			wantErr: true,
+		},
+		{
+			name:    "no alg, without fallback",
+			policy:  KeyPolicy{},
+			req:     pssSignedReq(t, string(actor.PublicKey.ID), prvKeyRSA1, false),
+			want:    AnonymousActor,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := httpSigVerifier{loader: loader, policy: tt.policy, l: lw.Dev(lw.SetOutput(t.Output()))}
+			got, err := k.VerifyRFCSignature(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRFCSignature() error = %v, wantErr %t", err, tt.wantErr)
+				return
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyRFCSignature() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+		})
+	}
+}
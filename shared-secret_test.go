@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	"github.com/dadrus/httpsig"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockSecrets map[string][]byte
+
+func (m mockSecrets) LoadSecret(keyID string) ([]byte, error) {
+	secret, ok := m[keyID]
+	if !ok {
+		return nil, errors.NotFoundf("not found %s", keyID)
+	}
+	return secret, nil
+}
+
+func signedReq(t *testing.T, key httpsig.Key) *http.Request {
+	req := mockGetReq()
+	sig, err := httpsig.NewSigner(key, httpsig.WithComponents("@method", "@authority"))
+	if err != nil {
+		t.Fatalf("unable to create signer: %s", err)
+	}
+	hdr, err := sig.Sign(httpsig.MessageFromRequest(req))
+	if err != nil {
+		t.Fatalf("unable to sign request: %s", err)
+	}
+	req.Header = hdr
+	return req
+}
+
+func Test_httpSigVerifier_VerifyRFCSignature_sharedSecret(t *testing.T) {
+	service := vocab.Actor{ID: "http://example.com/services/media", Type: vocab.ServiceType}
+	actor := mockRFCActor(prvKeyECDSA, "http://example.com/~jdoe#main")
+	secrets := mockSecrets{
+		"media-proxy": []byte("0123456789abcdef0123456789abcdef"),
+		"short":       []byte("0123456789"),
+	}
+	loader := &multiKeyLoader{actors: map[string]vocab.Actor{string(actor.PublicKey.ID): actor}}
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		want    vocab.Actor
+		wantErr bool
+	}{
+		{
+			name: "hmac signature",
+			req:  signedReq(t, httpsig.Key{KeyID: "media-proxy", Algorithm: httpsig.HmacSha256, Key: secrets["media-proxy"]}),
+			want: service,
+		},
+		{
+			name:    "hmac signature with wrong secret",
+			req:     signedReq(t, httpsig.Key{KeyID: "media-proxy", Algorithm: httpsig.HmacSha256, Key: []byte("fedcba9876543210fedcba9876543210")}),
+			want:    AnonymousActor,
+			wantErr: true,
+		},
+		{
+			name:    "hmac signature with short secret",
+			req:     signedReq(t, httpsig.Key{KeyID: "short", Algorithm: httpsig.HmacSha256, Key: secrets["short"]}),
+			want:    AnonymousActor,
+			wantErr: true,
+		},
+		{
+			name: "public key signature",
+			req:  signedReq(t, httpsig.Key{KeyID: string(actor.PublicKey.ID), Algorithm: httpsig.EcdsaP256Sha256, Key: prvKeyECDSA}),
+			want: actor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := httpSigVerifier{
+				loader:  loader,
+				secrets: secrets,
+				service: service,
+				policy:  DefaultKeyPolicy,
+				l:       lw.Dev(lw.SetOutput(t.Output())),
+			}
+			got, err := k.VerifyRFCSignature(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRFCSignature() error = %v, wantErr %t", err, tt.wantErr)
+				return
+			}
+			if !cmp.Equal(got, tt.want, EquateItems) {
+				t.Errorf("VerifyRFCSignature() got = %s", cmp.Diff(tt.want, got, EquateItems))
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dunglas/httpsfv"
+	vocab "github.com/go-ap/activitypub"
+)
+
+// VerificationMethod identifies the mechanism that was used for authorizing a request.
+type VerificationMethod string
+
+const (
+	MethodNone           VerificationMethod = ""
+	MethodOAuth2         VerificationMethod = "oauth2"
+	MethodDraftSignature VerificationMethod = "draft-cavage"
+	MethodRFCSignature   VerificationMethod = "rfc9421"
+)
+
+// Signature holds the metadata of a verified HTTP signature.
+type Signature struct {
+	// Label is the RFC9421 signature label, it is empty for draft-cavage signatures.
+	Label string
+	KeyID string
+	// Actor is the owner of the key that created the signature.
+	Actor vocab.Actor
+	// Algorithm is the name of the algorithm that verified the signature.
+	Algorithm string
+	// Components are the HTTP fields and derived components covered by the signature.
+	// For draft-cavage signatures these are the values of the "headers" parameter.
+	Components []string
+	// Created is the zero value when the signature didn't specify a creation time.
+	Created time.Time
+}
+
+// VerificationResult contains the actor that authorized a request together with
+// the details of how the authorization was performed.
+type VerificationResult struct {
+	Method     VerificationMethod
+	Actor      vocab.Actor
+	Signatures []Signature
+	// Scopes are the scopes granted to the OAuth2 access token.
+	Scopes []string
+	// ClientID is the ID of the OAuth2 client the access token was issued to.
+	ClientID string
+	// ClientRedirectURI is the redirect URI registered for the OAuth2 client.
+	ClientRedirectURI string
+	// ClientMetadata is the application specific data stored with the OAuth2 client.
+	ClientMetadata any
+	// KeyThumbprint is the RFC7638 thumbprint of the DPoP key the access token is bound to.
+	KeyThumbprint string
+	// CertificateThumbprint is the SHA-256 thumbprint of the TLS client certificate the access token is bound to.
+	CertificateThumbprint string
+}
+
+// anonymousResult is returned for requests that could not be, or did not need to be, authorized.
+func anonymousResult() VerificationResult {
+	return VerificationResult{Actor: AnonymousActor}
+}
+
+// rfcSignatures parses the Signature-Input header of a request into the list of signatures it describes.
+func rfcSignatures(header http.Header) []Signature {
+	inputDict, err := httpsfv.UnmarshalDictionary(header.Values("Signature-Input"))
+	if err != nil {
+		return nil
+	}
+
+	sigs := make([]Signature, 0, len(inputDict.Names()))
+	for _, label := range inputDict.Names() {
+		m, _ := inputDict.Get(label)
+		sigParams, ok := m.(httpsfv.InnerList)
+		if !ok {
+			continue
+		}
+
+		sig := Signature{Label: label}
+		for _, it := range sigParams.Items {
+			if c, ok := it.Value.(string); ok {
+				sig.Components = append(sig.Components, c)
+			}
+		}
+		if param, ok := sigParams.Params.Get("keyid"); ok {
+			sig.KeyID, _ = param.(string)
+		}
+		if param, ok := sigParams.Params.Get("alg"); ok {
+			sig.Algorithm, _ = param.(string)
+		}
+		if param, ok := sigParams.Params.Get("created"); ok {
+			if created, ok := param.(int64); ok {
+				sig.Created = time.Unix(created, 0).UTC()
+			}
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// draftSignature builds the signature metadata from the parameters of a draft-cavage signature.
+func draftSignature(params map[string]string, alg string) Signature {
+	sig := Signature{KeyID: params["keyId"], Algorithm: alg}
+	if headers, ok := params["headers"]; ok {
+		sig.Components = strings.Fields(headers)
+	} else {
+		// NOTE(marius): the draft specifies that when the headers parameter is missing only the Date header is signed
+		sig.Components = []string{"date"}
+	}
+	if created, ok := params["created"]; ok {
+		if ts, err := strconv.ParseInt(created, 10, 64); err == nil {
+			sig.Created = time.Unix(ts, 0).UTC()
+		}
+	}
+	return sig
+}
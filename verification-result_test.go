@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_rfcSignatures(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   []Signature
+	}{
+		{
+			name:   "empty",
+			header: http.Header{},
+			want:   []Signature{},
+		},
+		{
+			name:   "invalid",
+			header: http.Header{"Signature-Input": {"sig1=("}},
+		},
+		{
+			name: "one signature",
+			header: http.Header{
+				"Signature-Input": {`sig1=("@method" "@target-uri" "content-digest");created=1618884473;keyid="http://example.com/~jdoe#main";alg="rsa-pss-sha512"`},
+			},
+			want: []Signature{
+				{
+					Label:      "sig1",
+					KeyID:      "http://example.com/~jdoe#main",
+					Algorithm:  "rsa-pss-sha512",
+					Components: []string{"@method", "@target-uri", "content-digest"},
+					Created:    time.Unix(1618884473, 0).UTC(),
+				},
+			},
+		},
+		{
+			name: "two signatures",
+			header: http.Header{
+				"Signature-Input": {`sig1=("@method");keyid="http://example.com/~jdoe#main", proxy=("@authority" "date");created=1618884475;keyid="http://proxy.example.com/actor#main"`},
+			},
+			want: []Signature{
+				{
+					Label:      "sig1",
+					KeyID:      "http://example.com/~jdoe#main",
+					Components: []string{"@method"},
+				},
+				{
+					Label:      "proxy",
+					KeyID:      "http://proxy.example.com/actor#main",
+					Components: []string{"@authority", "date"},
+					Created:    time.Unix(1618884475, 0).UTC(),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rfcSignatures(tt.header); !cmp.Equal(got, tt.want) {
+				t.Errorf("rfcSignatures() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func Test_draftSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		alg    string
+		want   Signature
+	}{
+		{
+			name:   "no headers",
+			params: map[string]string{"keyId": "http://example.com/~jdoe#main"},
+			alg:    "rsa-sha256",
+			want: Signature{
+				KeyID:      "http://example.com/~jdoe#main",
+				Algorithm:  "rsa-sha256",
+				Components: []string{"date"},
+			},
+		},
+		{
+			name: "with headers and created",
+			params: map[string]string{
+				"keyId":   "http://example.com/~jdoe#main",
+				"headers": "(request-target) host date digest",
+				"created": "1618884473",
+			},
+			alg: "hs2019",
+			want: Signature{
+				KeyID:      "http://example.com/~jdoe#main",
+				Algorithm:  "hs2019",
+				Components: []string{"(request-target)", "host", "date", "digest"},
+				Created:    time.Unix(1618884473, 0).UTC(),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := draftSignature(tt.params, tt.alg); !cmp.Equal(got, tt.want) {
+				t.Errorf("draftSignature() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}